@@ -0,0 +1,346 @@
+// 可嵌入beego应用的角色管理页面，基于filter.ApiAuthService管理当前client的角色、成员和资源关联
+//
+//	admin.Register(&admin.Options{
+//		Prefix:        "/rbac",
+//		Api:           apiAuth,
+//		Auth:          authService,
+//		AdminResource: "rbac:admin",
+//		AuditSink:     sink,
+//	})
+//
+// 页面访问前依次调用Auth的CheckLoginFilter和CheckAuthorityFilter，并直接校验AdminResource，
+// Auth未配置对应UrlControl时同样拒绝无权限的用户；Auth实现AddUrlControl时自动为全部页面添加AdminResource规则。
+// 全部修改请求校验CSRF token并记录审计事件，参数不合法、修改失败和被拒绝的请求同样记录。
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/utils"
+	"github.com/tongwu13/golang_common/auth/filter"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_PREFIX  = "/rbac"
+	csrfCookie      = "_rbac_csrf"
+	csrfField       = "_csrf"
+	csrfCookieHours = 12
+)
+
+type Options struct {
+	Prefix        string                // 页面路径前缀，默认为/rbac
+	Api           filter.ApiAuthService // 管理的client
+	Auth          filter.AuthService    // 登录及鉴权
+	AdminResource string                // 访问管理页面所需的规则（资源Data、role:xxx或roletype:xxx）
+	AuditSink     filter.AuditSink      // 修改的审计事件输出，为空时只记录日志
+	CSRFKey       string                // CSRF cookie的签名key，为空时随机生成（多副本部署时需配置相同的值）
+	Logger        filter.Logger         // 日志输出，会经过filter的脱敏处理，为空时输出到beego/logs
+}
+
+// 可追加UrlControl规则的AuthService，filter.Auth和filter.MultiAuth均已实现
+type urlControlAdder interface {
+	AddUrlControl(key string, rules ...string)
+}
+
+// 可按自身用户存储校验规则的AuthService，filter.Auth和filter.MultiAuth均已实现
+type requirer interface {
+	Require(ctx *context.Context, rules ...string) error
+}
+
+// 注册管理页面的路由
+func Register(opts *Options) error {
+	return register(beego.BeeApp.Handlers, opts)
+}
+
+func register(router *beego.ControllerRegister, opts *Options) error {
+	if opts == nil || opts.Api == nil || opts.Auth == nil {
+		return errors.New("admin: Api and Auth are required")
+	}
+	if opts.AdminResource == "" {
+		return errors.New("admin: AdminResource is required")
+	}
+	prefix := strings.TrimSuffix(opts.Prefix, "/")
+	if prefix == "" {
+		prefix = DEFAULT_PREFIX
+	}
+	if opts.CSRFKey == "" {
+		opts.CSRFKey = string(utils.RandomCreateBytes(32))
+	}
+	opts.Logger = filter.NewRedactingLogger(opts.Logger)
+
+	routes := []struct {
+		pattern, mapping string
+	}{
+		{prefix, "get:RoleTree"},
+		{prefix + "/resources", "get:Resources"},
+		{prefix + "/roles", "post:AddRole"},
+		{prefix + "/roles/:id:int", "get:Role"},
+		{prefix + "/roles/:id:int/delete", "post:DeleteRole"},
+		{prefix + "/roles/:id:int/users", "post:AddUser"},
+		{prefix + "/roles/:id:int/users/delete", "post:DeleteUser"},
+		{prefix + "/roles/:id:int/relations", "post:UpdateRelations"},
+	}
+	adder, canAdd := opts.Auth.(urlControlAdder)
+	for _, r := range routes {
+		router.Add(r.pattern, &Controller{Admin: opts, Prefix: prefix}, r.mapping)
+		if canAdd {
+			adder.AddUrlControl(r.pattern, opts.AdminResource)
+		}
+	}
+	return nil
+}
+
+// 管理页面的控制器，由Register注册，字段在注册时设置
+type Controller struct {
+	beego.Controller
+	Admin  *Options
+	Prefix string
+}
+
+// 登录和鉴权，未通过时结束请求
+func (c *Controller) Prepare() {
+	c.Admin.Auth.CheckLoginFilter(c.Ctx)
+	if c.Ctx.ResponseWriter.Started {
+		c.StopRun()
+	}
+	pattern, _ := c.Ctx.Input.GetData("RouterPattern").(string)
+	c.Admin.Auth.CheckAuthorityFilter(c.Ctx, pattern)
+	if c.Ctx.ResponseWriter.Started {
+		c.StopRun()
+	}
+	if err := c.requireAdmin(); err != nil {
+		c.deny(err)
+	}
+	if c.Ctx.Request.Method == http.MethodPost && !c.checkCSRF() {
+		c.deny(errors.New("invalid csrf token"))
+	}
+}
+
+// 记录拒绝的请求并结束
+func (c *Controller) deny(err error) {
+	event := &filter.AuditEvent{Type: filter.AUDIT_ADMIN_DENY, Reason: err.Error()}
+	if e, ok := err.(*filter.PermissionError); ok {
+		event.Missing = e.Missing
+	}
+	c.audit(event)
+	c.Admin.Logger.Warn("admin request denied", filter.F("user_id", event.UserId), filter.F("route", event.Route), filter.F("reason", event.Reason))
+	c.Ctx.ResponseWriter.WriteHeader(http.StatusForbidden)
+	c.Ctx.WriteString(err.Error())
+	c.StopRun()
+}
+
+// 补充当前用户和请求信息后输出审计事件
+func (c *Controller) audit(event *filter.AuditEvent) {
+	event.Time = time.Now()
+	event.UserId = c.Admin.Auth.CurrentUser(c.Ctx).Id
+	event.IP = c.Ctx.Input.IP()
+	event.Method = c.Ctx.Request.Method
+	event.Route = c.Ctx.Input.URL()
+	if c.Admin.AuditSink != nil {
+		c.Admin.AuditSink.Emit(event)
+	}
+}
+
+// 不依赖UrlControl，直接校验AdminResource
+func (c *Controller) requireAdmin() error {
+	rules := strings.Split(c.Admin.AdminResource, "|")
+	if r, ok := c.Admin.Auth.(requirer); ok {
+		return r.Require(c.Ctx, rules...)
+	}
+	return filter.Require(c.Ctx, rules...)
+}
+
+func (c *Controller) csrfToken() string {
+	if token, ok := c.Ctx.GetSecureCookie(c.Admin.CSRFKey, csrfCookie); ok && token != "" {
+		return token
+	}
+	token := string(utils.RandomCreateBytes(32))
+	c.Ctx.SetSecureCookie(c.Admin.CSRFKey, csrfCookie, token, csrfCookieHours*3600, c.Prefix, "", false, true)
+	return token
+}
+
+func (c *Controller) checkCSRF() bool {
+	expected, ok := c.Ctx.GetSecureCookie(c.Admin.CSRFKey, csrfCookie)
+	token := c.GetString(csrfField)
+	return ok && expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+func (c *Controller) render(name string, data map[string]interface{}) {
+	c.renderStatus(http.StatusOK, name, data)
+}
+
+func (c *Controller) renderStatus(status int, name string, data map[string]interface{}) {
+	data["Prefix"] = c.Prefix
+	data["CSRF"] = c.csrfToken()
+	data["User"] = c.Admin.Auth.CurrentUser(c.Ctx)
+	c.Ctx.Output.Header("Content-Type", "text/html; charset=utf-8")
+	c.Ctx.ResponseWriter.WriteHeader(status)
+	if err := templates.ExecuteTemplate(c.Ctx.ResponseWriter, name, data); err != nil {
+		c.Admin.Logger.Error("admin render failed", filter.F("template", name), filter.F("error", err))
+	}
+}
+
+func (c *Controller) fail(err error) {
+	c.renderStatus(http.StatusInternalServerError, "error", map[string]interface{}{"Error": err.Error()})
+}
+
+// 修改完成（或参数不合法）后记录审计事件，成功时跳回
+func (c *Controller) done(err error, target, detail, back string) {
+	event := &filter.AuditEvent{Type: filter.AUDIT_ADMIN_CHANGE, Target: target, Detail: detail}
+	if err != nil {
+		event.Reason = err.Error()
+	}
+	c.audit(event)
+	c.Admin.Logger.Info("admin change", filter.F("user_id", event.UserId), filter.F("target", target), filter.F("detail", detail), filter.F("error", err))
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.Redirect(back, http.StatusSeeOther)
+}
+
+func (c *Controller) roleId() int {
+	id, _ := strconv.Atoi(c.Ctx.Input.Param(":id"))
+	return id
+}
+
+func (c *Controller) rolePage(id int) string {
+	return fmt.Sprintf("%s/roles/%d", c.Prefix, id)
+}
+
+// 角色树
+func (c *Controller) RoleTree() {
+	trees, err := c.Admin.Api.GetRoleTree(false, false)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	type row struct {
+		Role  *filter.RoleTree
+		Depth int
+	}
+	var rows []row
+	filter.RoleForest(trees).Walk(func(node *filter.RoleTree, depth int) error {
+		rows = append(rows, row{node, depth})
+		return nil
+	})
+	c.render("tree", map[string]interface{}{"Rows": rows})
+}
+
+// 资源列表
+func (c *Controller) Resources() {
+	resources, err := c.Admin.Api.GetAllResources()
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.render("resources", map[string]interface{}{"Resources": resources})
+}
+
+// 角色详情：成员和资源关联
+func (c *Controller) Role() {
+	id := c.roleId()
+	trees, err := c.Admin.Api.GetRoleTree(false, false)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	forest := filter.RoleForest(trees)
+	role := forest.Find(id)
+	if role == nil {
+		c.renderStatus(http.StatusNotFound, "error", map[string]interface{}{"Error": fmt.Sprintf("role %d not found", id)})
+		return
+	}
+	users, err := c.Admin.Api.GetUsersOfRole(id)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	related, err := c.Admin.Api.GetRelatedInfo(id)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	resources, err := c.Admin.Api.GetAllResources()
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	checked := make(map[int]bool, len(related))
+	for _, r := range related {
+		checked[r.ResourceId] = true
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserId < users[j].UserId })
+	c.render("role", map[string]interface{}{
+		"Role":      role,
+		"Path":      forest.Path(id),
+		"Users":     users,
+		"Resources": resources,
+		"Checked":   checked,
+	})
+}
+
+func (c *Controller) AddRole() {
+	name := strings.TrimSpace(c.GetString("name"))
+	parentId, _ := c.GetInt("parent_id")
+	detail := fmt.Sprintf("add role %s under %d", name, parentId)
+	if name == "" {
+		c.done(errors.New("role name is empty"), "", detail, "")
+		return
+	}
+	id, err := c.Admin.Api.AddRole(name, c.GetString("description"), parentId)
+	c.done(err, strconv.Itoa(id), detail, c.rolePage(id))
+}
+
+func (c *Controller) DeleteRole() {
+	id := c.roleId()
+	_, err := c.Admin.Api.DeleteRole(id)
+	c.done(err, strconv.Itoa(id), "delete role", c.Prefix)
+}
+
+func (c *Controller) AddUser() {
+	id := c.roleId()
+	info := filter.UserInfo{UserId: strings.TrimSpace(c.GetString("user_id")), RoleType: c.GetString("role_type")}
+	detail := fmt.Sprintf("add user %s as %s", info.UserId, info.RoleType)
+	if info.UserId == "" {
+		c.done(errors.New("user id is empty"), strconv.Itoa(id), detail, "")
+		return
+	}
+	_, err := c.Admin.Api.AddUserToRole(id, []filter.UserInfo{info})
+	c.done(err, strconv.Itoa(id), detail, c.rolePage(id))
+}
+
+func (c *Controller) DeleteUser() {
+	id := c.roleId()
+	userId := strings.TrimSpace(c.GetString("user_id"))
+	if userId == "" {
+		c.done(errors.New("user id is empty"), strconv.Itoa(id), "delete user", "")
+		return
+	}
+	_, err := c.Admin.Api.DeleteUserFromRole(id, []string{userId})
+	c.done(err, strconv.Itoa(id), "delete user "+userId, c.rolePage(id))
+}
+
+func (c *Controller) UpdateRelations() {
+	id := c.roleId()
+	var resIds []int
+	for _, v := range c.GetStrings("res_id") {
+		resId, err := strconv.Atoi(v)
+		if err != nil || resId <= 0 {
+			c.done(fmt.Errorf("invalid resource id %q", v), strconv.Itoa(id), "update relations", "")
+			return
+		}
+		resIds = append(resIds, resId)
+	}
+	sort.Ints(resIds)
+	_, err := c.Admin.Api.UpdateRelations(id, resIds)
+	c.done(err, strconv.Itoa(id), fmt.Sprintf("update relations %v", resIds), c.rolePage(id))
+}
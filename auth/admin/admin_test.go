@@ -0,0 +1,129 @@
+package admin
+
+import (
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/tongwu13/golang_common/auth/filter"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	headerTestUser = "X-Test-User"
+	testCSRFKey    = "csrf-key"
+	testCSRFToken  = "token"
+)
+
+// 按请求头X-Test-User选择用户，admin拥有rbac:admin
+type fakeAuth struct {
+	filter.AuthService
+}
+
+func (fakeAuth) CheckLoginFilter(ctx *context.Context)                           {}
+func (fakeAuth) CheckAuthorityFilter(ctx *context.Context, routerPattern string) {}
+
+func (fakeAuth) CurrentUser(ctx *context.Context) filter.User {
+	return filter.User{Id: ctx.Input.Header(headerTestUser)}
+}
+
+func (fakeAuth) Require(ctx *context.Context, rules ...string) error {
+	if ctx.Input.Header(headerTestUser) != "admin" {
+		return &filter.PermissionError{UserId: ctx.Input.Header(headerTestUser), Missing: rules}
+	}
+	return nil
+}
+
+type fakeApi struct {
+	filter.ApiAuthService
+	calls int
+}
+
+func (f *fakeApi) AddRole(name, description string, parentId int) (int, error) {
+	f.calls++
+	return 3, nil
+}
+
+type recordingSink struct {
+	events []*filter.AuditEvent
+}
+
+func (s *recordingSink) Emit(event *filter.AuditEvent) {
+	s.events = append(s.events, event)
+}
+
+// 与Controller.csrfToken相同方式签名的cookie
+func newCSRFCookie(t *testing.T) *http.Cookie {
+	w := httptest.NewRecorder()
+	ctx := context.NewContext()
+	ctx.Reset(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	ctx.SetSecureCookie(testCSRFKey, csrfCookie, testCSRFToken)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies = %v", cookies)
+	}
+	return cookies[0]
+}
+
+func TestAdminAudit(t *testing.T) {
+	cases := []struct {
+		name       string
+		method     string
+		target     string
+		user       string
+		form       url.Values
+		csrf       bool
+		wantStatus int
+		wantType   string
+		wantReason string
+		wantCalls  int
+	}{
+		{name: "missing admin resource", method: http.MethodGet, target: "/rbac", user: "guest",
+			wantStatus: http.StatusForbidden, wantType: filter.AUDIT_ADMIN_DENY, wantReason: "rbac:admin"},
+		{name: "missing csrf", method: http.MethodPost, target: "/rbac/roles", user: "admin", form: url.Values{"name": {"ops"}},
+			wantStatus: http.StatusForbidden, wantType: filter.AUDIT_ADMIN_DENY, wantReason: "invalid csrf token"},
+		{name: "empty role name", method: http.MethodPost, target: "/rbac/roles", user: "admin", form: url.Values{"name": {" "}}, csrf: true,
+			wantStatus: http.StatusInternalServerError, wantType: filter.AUDIT_ADMIN_CHANGE, wantReason: "role name is empty"},
+		{name: "add role", method: http.MethodPost, target: "/rbac/roles", user: "admin", form: url.Values{"name": {"ops"}}, csrf: true,
+			wantStatus: http.StatusSeeOther, wantType: filter.AUDIT_ADMIN_CHANGE, wantCalls: 1},
+	}
+	for _, c := range cases {
+		api, sink := &fakeApi{}, &recordingSink{}
+		router := beego.NewControllerRegister()
+		err := register(router, &Options{Api: api, Auth: fakeAuth{}, AdminResource: "rbac:admin", AuditSink: sink, CSRFKey: testCSRFKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := c.form
+		if c.csrf {
+			form.Set(csrfField, testCSRFToken)
+		}
+		r := httptest.NewRequest(c.method, c.target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set(headerTestUser, c.user)
+		if c.csrf {
+			r.AddCookie(newCSRFCookie(t))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		if w.Code != c.wantStatus {
+			t.Errorf("%s: status = %d, want %d", c.name, w.Code, c.wantStatus)
+		}
+		if api.calls != c.wantCalls {
+			t.Errorf("%s: api calls = %d, want %d", c.name, api.calls, c.wantCalls)
+		}
+		if len(sink.events) != 1 {
+			t.Fatalf("%s: events = %d, want 1", c.name, len(sink.events))
+		}
+		event := sink.events[0]
+		if event.Type != c.wantType || !strings.Contains(event.Reason, c.wantReason) || event.UserId != c.user || event.Route != c.target {
+			t.Errorf("%s: event = %+v", c.name, event)
+		}
+		if c.wantReason == "" && event.Reason != "" {
+			t.Errorf("%s: unexpected reason %q", c.name, event.Reason)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"html/template"
+	"strings"
+)
+
+var templates = template.Must(template.New("admin").Funcs(template.FuncMap{
+	"indent": func(depth int) string { return strings.Repeat("　", depth) },
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>角色管理</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+nav a { margin-right: 1em; }
+form.inline { display: inline; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<nav><a href="{{.Prefix}}">角色</a><a href="{{.Prefix}}/resources">资源</a><span>{{.User.Fullname}}</span></nav>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "tree"}}{{template "header" .}}
+<h2>角色</h2>
+<table>
+<tr><th>角色</th><th>id</th><th>描述</th></tr>
+{{range .Rows}}<tr><td>{{indent .Depth}}<a href="{{$.Prefix}}/roles/{{.Role.Id}}">{{.Role.Name}}</a></td><td>{{.Role.Id}}</td><td>{{.Role.Description}}</td></tr>
+{{end}}</table>
+<h3>新增角色</h3>
+<form method="post" action="{{.Prefix}}/roles">
+<input type="hidden" name="_csrf" value="{{.CSRF}}">
+名称 <input name="name" required> 描述 <input name="description"> 父角色id <input name="parent_id" value="0" size="4">
+<button type="submit">新增</button>
+</form>
+{{template "footer" .}}{{end}}
+
+{{define "resources"}}{{template "header" .}}
+<h2>资源</h2>
+<table>
+<tr><th>id</th><th>名称</th><th>Data</th><th>描述</th></tr>
+{{range .Resources}}<tr><td>{{.Id}}</td><td>{{.Name}}</td><td>{{.Data}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>
+{{template "footer" .}}{{end}}
+
+{{define "role"}}{{template "header" .}}
+<h2>{{.Path}}</h2>
+<p>{{.Role.Description}}</p>
+<form class="inline" method="post" action="{{.Prefix}}/roles/{{.Role.Id}}/delete" onsubmit="return confirm('删除角色 {{.Role.Name}}？')">
+<input type="hidden" name="_csrf" value="{{.CSRF}}">
+<button type="submit">删除角色</button>
+</form>
+
+<h3>成员</h3>
+<table>
+<tr><th>用户</th><th>类型</th><th></th></tr>
+{{range .Users}}<tr><td>{{.UserId}}</td><td>{{.RoleType}}</td><td>
+<form class="inline" method="post" action="{{$.Prefix}}/roles/{{$.Role.Id}}/users/delete">
+<input type="hidden" name="_csrf" value="{{$.CSRF}}">
+<input type="hidden" name="user_id" value="{{.UserId}}">
+<button type="submit">移除</button>
+</form></td></tr>
+{{end}}</table>
+<form method="post" action="{{.Prefix}}/roles/{{.Role.Id}}/users">
+<input type="hidden" name="_csrf" value="{{.CSRF}}">
+用户id <input name="user_id" required> 类型 <input name="role_type">
+<button type="submit">添加成员</button>
+</form>
+
+<h3>资源关联</h3>
+<form method="post" action="{{.Prefix}}/roles/{{.Role.Id}}/relations">
+<input type="hidden" name="_csrf" value="{{.CSRF}}">
+<table>
+<tr><th></th><th>资源</th><th>Data</th></tr>
+{{range .Resources}}<tr><td><input type="checkbox" name="res_id" value="{{.Id}}"{{if index $.Checked .Id}} checked{{end}}></td><td>{{.Name}}</td><td>{{.Data}}</td></tr>
+{{end}}</table>
+<button type="submit">保存</button>
+</form>
+{{template "footer" .}}{{end}}
+
+{{define "error"}}{{template "header" .}}
+<p class="error">{{.Error}}</p>
+<p><a href="javascript:history.back()">返回</a></p>
+{{template "footer" .}}{{end}}
+`))
@@ -0,0 +1,308 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/astaxie/beego/context"
+	"strings"
+)
+
+/*
+基于属性的访问控制（ABAC）
+
+资源Data可以是JSON，用于给资源附加属性，例如：
+	{"key":"order:edit","attrs":{"tenant":"t1","region":"cn"}}
+此时ResourceMap以key作为资源标识，attrs作为该资源的属性。
+
+AbacRules配置的key与UrlControl相同（url或method:url），value为若干条规则，以|分隔，全部满足才允许访问：
+	order:edit when order.tenant == path.tenant && user.region != 'us'
+规则中when之前为资源标识（用户需拥有该资源，与RBAC一致），when之后为条件，条件之间以&&连接，支持==和!=。
+操作数：
+	path.xxx     路由参数（:xxx）
+	query.xxx    query参数
+	header.xxx   请求头
+	user.xxx     用户属性（id、fullname、dn以及sso返回的attrs）
+	resource.xxx 资源属性，也可以使用资源标识中:之前的部分作为前缀，如order.tenant
+	'xxx'或"xxx" 字面量
+用户拥有多个相同标识的资源时（如分别属于不同tenant），任一资源满足条件即可。
+*/
+
+// 请求属性，用于ABAC条件计算
+type RequestAttrs struct {
+	Path   map[string]string // 路由参数，不含:前缀
+	Query  map[string]string
+	Header map[string]string // key为小写
+}
+
+// 从请求上下文中提取属性
+func RequestAttrsFromContext(ctx *context.Context) RequestAttrs {
+	attrs := RequestAttrs{
+		Path:   make(map[string]string),
+		Query:  make(map[string]string),
+		Header: make(map[string]string),
+	}
+	for k, v := range ctx.Input.Params() {
+		attrs.Path[strings.TrimPrefix(k, ":")] = v
+	}
+	for k, v := range ctx.Request.URL.Query() {
+		if len(v) > 0 {
+			attrs.Query[k] = v[0]
+		}
+	}
+	for k, v := range ctx.Request.Header {
+		if len(v) > 0 {
+			attrs.Header[strings.ToLower(k)] = v[0]
+		}
+	}
+	return attrs
+}
+
+type abacOperand struct {
+	scope   string // 为空表示字面量
+	name    string
+	literal string
+}
+
+type abacCondition struct {
+	left   abacOperand
+	right  abacOperand
+	negate bool
+}
+
+// 一条ABAC规则，由ParseAbacRules解析
+type AbacRule struct {
+	raw        string
+	resource   string
+	namespace  string // 资源标识中:之前的部分，可作为resource的别名
+	conditions []abacCondition
+}
+
+// 规则原文
+func (r *AbacRule) String() string {
+	return r.raw
+}
+
+// 解析一组以|分隔的ABAC规则，字面量中的|、&&、==、!=不作为分隔符或运算符
+func ParseAbacRules(value string) ([]*AbacRule, error) {
+	parts, err := splitOutsideQuotes(value, "|")
+	if err != nil {
+		return nil, fmt.Errorf("abac rules %q: %v", value, err)
+	}
+	var rules []*AbacRule
+	for _, raw := range parts {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		rule := &AbacRule{raw: raw}
+		condition := ""
+		if idx := indexOutsideQuotes(raw, " when "); idx >= 0 {
+			rule.resource = strings.TrimSpace(raw[:idx])
+			condition = strings.TrimSpace(raw[idx+len(" when "):])
+			if condition == "" {
+				return nil, fmt.Errorf("abac rule %q: condition is empty", raw)
+			}
+		} else {
+			rule.resource = raw
+		}
+		if rule.resource == "" || strings.ContainsAny(rule.resource, "'\" \t") {
+			return nil, fmt.Errorf("abac rule %q: invalid resource %q", raw, rule.resource)
+		}
+		rule.namespace = strings.SplitN(rule.resource, ":", 2)[0]
+		if condition != "" {
+			clauses, _ := splitOutsideQuotes(condition, "&&")
+			for _, clause := range clauses {
+				c, err := parseAbacCondition(strings.TrimSpace(clause))
+				if err != nil {
+					return nil, fmt.Errorf("abac rule %q: %v", raw, err)
+				}
+				rule.conditions = append(rule.conditions, c)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// sep在引号外第一次出现的位置，没有时返回-1
+func indexOutsideQuotes(s, sep string) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case strings.HasPrefix(s[i:], sep):
+			return i
+		}
+	}
+	return -1
+}
+
+// 按引号外的sep切分，引号未闭合时返回错误
+func splitOutsideQuotes(s, sep string) ([]string, error) {
+	var parts []string
+	var quote byte
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[last:i])
+			i += len(sep) - 1
+			last = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	return append(parts, s[last:]), nil
+}
+
+func parseAbacCondition(clause string) (abacCondition, error) {
+	c := abacCondition{}
+	eq, ne := indexOutsideQuotes(clause, "=="), indexOutsideQuotes(clause, "!=")
+	idx := eq
+	switch {
+	case eq < 0 && ne < 0:
+		return c, fmt.Errorf("condition %q: expect == or !=", clause)
+	case eq >= 0 && ne >= 0:
+		return c, fmt.Errorf("condition %q: only one operator is allowed", clause)
+	case ne >= 0:
+		idx = ne
+		c.negate = true
+	}
+	left, right := strings.TrimSpace(clause[:idx]), strings.TrimSpace(clause[idx+2:])
+	if indexOutsideQuotes(right, "==") >= 0 || indexOutsideQuotes(right, "!=") >= 0 {
+		return c, fmt.Errorf("condition %q: only one operator is allowed", clause)
+	}
+	var err error
+	if c.left, err = parseAbacOperand(left); err != nil {
+		return c, err
+	}
+	if c.right, err = parseAbacOperand(right); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+func parseAbacOperand(s string) (abacOperand, error) {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		literal := s[1 : len(s)-1]
+		if strings.IndexByte(literal, s[0]) >= 0 {
+			return abacOperand{}, fmt.Errorf("operand %q: unexpected quote in literal", s)
+		}
+		return abacOperand{literal: literal}, nil
+	}
+	parts := strings.SplitN(s, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.ContainsAny(s, "'\" \t") {
+		return abacOperand{}, fmt.Errorf("operand %q: expect scope.name or quoted literal", s)
+	}
+	return abacOperand{scope: parts[0], name: parts[1]}, nil
+}
+
+func (o abacOperand) value(rule *AbacRule, user *User, resource *Resource, req RequestAttrs) string {
+	switch o.scope {
+	case "":
+		return o.literal
+	case "path":
+		return req.Path[o.name]
+	case "query":
+		return req.Query[o.name]
+	case "header":
+		return req.Header[strings.ToLower(o.name)]
+	case "user":
+		return user.attr(o.name)
+	case "resource", rule.namespace:
+		return resource.Attrs[o.name]
+	default:
+		return ""
+	}
+}
+
+// 用户拥有的资源中是否有满足条件的
+func (r *AbacRule) allows(user *User, req RequestAttrs) bool {
+	for _, resource := range user.Resources {
+		if !strings.EqualFold(resource.key(), r.resource) {
+			continue
+		}
+		pass := true
+		for _, c := range r.conditions {
+			equal := c.left.value(r, user, resource, req) == c.right.value(r, user, resource, req)
+			if equal == c.negate {
+				pass = false
+				break
+			}
+		}
+		if pass {
+			return true
+		}
+	}
+	return false
+}
+
+// 用户不满足的ABAC规则
+func abacMissing(user *User, rules []*AbacRule, req RequestAttrs) []string {
+	var missing []string
+	for _, rule := range rules {
+		if !rule.allows(user, req) {
+			missing = append(missing, rule.raw)
+		}
+	}
+	return missing
+}
+
+// Data为JSON时附带的资源属性
+type resourcePayload struct {
+	Key   string                 `json:"key"`
+	Attrs map[string]interface{} `json:"attrs"`
+}
+
+// 解析资源Data中的属性，Data不是JSON时资源标识即为Data
+// 返回解析了Key和Attrs的副本，不修改r（r可能为快照或缓存中多个请求共享的资源）
+func (r *Resource) parsed() *Resource {
+	c := &Resource{Id: r.Id, Description: r.Description, Data: r.Data, Key: r.Data}
+	if !strings.HasPrefix(strings.TrimSpace(r.Data), "{") {
+		return c
+	}
+	payload := resourcePayload{}
+	if err := json.Unmarshal([]byte(r.Data), &payload); err != nil || payload.Key == "" {
+		return c
+	}
+	c.Key = payload.Key
+	c.Attrs = make(map[string]string)
+	for k, v := range payload.Attrs {
+		c.Attrs[k] = fmt.Sprint(v)
+	}
+	return c
+}
+
+// 资源标识
+func (r *Resource) key() string {
+	if r.Key != "" {
+		return r.Key
+	}
+	return r.Data
+}
+
+// 用户属性
+func (u *User) attr(name string) string {
+	switch name {
+	case "id":
+		return u.Id
+	case "fullname":
+		return u.Fullname
+	case "dn":
+		return u.Dn
+	default:
+		return u.Attrs[name]
+	}
+}
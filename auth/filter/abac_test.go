@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAbacRules(t *testing.T) {
+	cases := []struct {
+		value   string
+		rules   []string // 规则原文
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"order:edit", []string{"order:edit"}, false},
+		{"order:edit | order:view ", []string{"order:edit", "order:view"}, false},
+		{"order:edit when order.tenant == path.tenant && user.region != 'us'", []string{"order:edit when order.tenant == path.tenant && user.region != 'us'"}, false},
+		{"order:edit when user.note == 'a|b' | order:view", []string{"order:edit when user.note == 'a|b'", "order:view"}, false},
+		{`order:edit when user.note == "x && y == z"`, []string{`order:edit when user.note == "x && y == z"`}, false},
+		{"order:edit when user.note != 'a!=b'", []string{"order:edit when user.note != 'a!=b'"}, false},
+		{"order:edit when", nil, true},
+		{"order:edit when user.region", nil, true},
+		{"order:edit when user.region == 'us' == 'cn'", nil, true},
+		{"order:edit when user.region == 'us' != 'cn'", nil, true},
+		{"order:edit when user.region == 'us", nil, true},
+		{"order:edit when region == 'us'", nil, true},
+		{"order:edit when user.region == us", nil, true},
+		{"order:edit when user.region == 'u's'", nil, true},
+		{"order:edit when user.re gion == 'us'", nil, true},
+		{"'order:edit' when user.region == 'us'", nil, true},
+		{"order edit", nil, true},
+	}
+	for _, c := range cases {
+		rules, err := ParseAbacRules(c.value)
+		if (err != nil) != c.wantErr {
+			t.Errorf("ParseAbacRules(%q) error = %v, wantErr %v", c.value, err, c.wantErr)
+			continue
+		}
+		var raws []string
+		for _, r := range rules {
+			raws = append(raws, r.String())
+		}
+		if !reflect.DeepEqual(raws, c.rules) {
+			t.Errorf("ParseAbacRules(%q) = %q, want %q", c.value, raws, c.rules)
+		}
+	}
+}
+
+func TestAbacRuleAllows(t *testing.T) {
+	user := &User{Id: "tom", Attrs: map[string]string{"region": "cn", "note": "a|b"}}
+	user.setResources([]*Resource{
+		{Id: 1, Data: `{"key":"order:edit","attrs":{"tenant":"t1"}}`},
+		{Id: 2, Data: `{"key":"order:edit","attrs":{"tenant":"t2"}}`},
+		{Id: 3, Data: "order:view"},
+	})
+	req := RequestAttrs{
+		Path:   map[string]string{"tenant": "t2"},
+		Query:  map[string]string{"mode": "full"},
+		Header: map[string]string{"x-region": "cn"},
+	}
+	cases := []struct {
+		rule string
+		want bool
+	}{
+		{"order:view", true},
+		{"order:delete", false},
+		{"order:edit when order.tenant == path.tenant", true},
+		{"order:edit when resource.tenant == 't1'", true},
+		{"order:edit when order.tenant == 't3'", false},
+		{"order:edit when order.tenant != 't1' && order.tenant != 't2'", false},
+		{"order:edit when user.region == header.x-region && query.mode == 'full'", true},
+		{"order:edit when user.region != 'cn'", false},
+		{"order:edit when user.id == 'tom'", true},
+		{"order:edit when user.note == 'a|b'", true},
+		{"order:view when order.tenant == ''", true},
+		{"order:edit when query.missing == 'x'", false},
+	}
+	for _, c := range cases {
+		rules, err := ParseAbacRules(c.rule)
+		if err != nil {
+			t.Fatalf("ParseAbacRules(%q): %v", c.rule, err)
+		}
+		if got := rules[0].allows(user, req); got != c.want {
+			t.Errorf("%q allows = %v, want %v", c.rule, got, c.want)
+		}
+	}
+}
+
+// 解析资源属性不修改共享的资源
+func TestResourceParsedCopy(t *testing.T) {
+	shared := &Resource{Id: 1, Data: `{"key":"order:edit","attrs":{"tenant":"t1","level":2}}`}
+	cases := []struct {
+		resource  *Resource
+		wantKey   string
+		wantAttrs map[string]string
+	}{
+		{shared, "order:edit", map[string]string{"tenant": "t1", "level": "2"}},
+		{&Resource{Data: "order:view"}, "order:view", nil},
+		{&Resource{Data: "{invalid"}, "{invalid", nil},
+		{&Resource{Data: `{"attrs":{"a":"b"}}`}, `{"attrs":{"a":"b"}}`, nil},
+	}
+	for _, c := range cases {
+		before := *c.resource
+		got := c.resource.parsed()
+		if got.key() != c.wantKey || !reflect.DeepEqual(got.Attrs, c.wantAttrs) {
+			t.Errorf("parsed(%q) = %q %v, want %q %v", c.resource.Data, got.key(), got.Attrs, c.wantKey, c.wantAttrs)
+		}
+		if !reflect.DeepEqual(*c.resource, before) {
+			t.Errorf("parsed(%q) modified the resource", c.resource.Data)
+		}
+	}
+}
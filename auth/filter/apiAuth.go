@@ -1,9 +1,6 @@
 package filter
 
-import (
-	"fmt"
-	"strconv"
-)
+import "fmt"
 
 type ApiAuthService interface {
 	GetClientById(id int) (*Client, error)
@@ -11,6 +8,7 @@ type ApiAuthService interface {
 	UpdateClient(fullname, redirectUri string) (*ClientInfo, error)
 
 	GetAllResources() ([]*ApiResource, error)
+	ListResources(opts ListOptions) (*ResourcePage, error)
 	GetUserResources(userId string) ([]*ApiResource, error)
 	AddResource(resources []ResourceInfo) ([]int, error)
 	UpdateResource(rId int, rName, rDescription, rData string) (*ApiResource, error)
@@ -19,17 +17,20 @@ type ApiAuthService interface {
 	GetRoleTree(relatedResource, relatedUser bool) ([]*RoleTree, error)
 	GetUserRoleTree(userId string, relatedResource, relatedUser bool) ([]*UserRoleTree, error)
 	GetAllRole(relatedResource, relatedUser bool) ([]*Role, error)
+	ListRoles(relatedResource, relatedUser bool, opts ListOptions) (*RolePage, error)
 	GetUserRoles(userId string, isAll, relatedResource, relatedUser bool) ([]*UserRole, error)
 	AddRole(name, description string, parentId int) (int, error)
 	UpdateRole(roleId int, name, description string, parentId int) (*Role, error)
 	DeleteRole(roleId int) (*DeleteRoleInfo, error)
 
 	GetUsersOfRole(roleId int) ([]*RoleUser, error)
+	ListUsersOfRole(roleId int, opts ListOptions) (*RoleUserPage, error)
 	AddUserToRole(roleId int, infos []UserInfo) (int, error)
 	UpdateUserOfRole(roleId int, info UserInfo) (*RoleUser, error)
 	DeleteUserFromRole(roleId int, names []string) (int, error)
 
 	GetAllRelatedInfo() ([]*RelatedInfo, error)
+	ListRelatedInfo(opts ListOptions) (*RelatedInfoPage, error)
 	GetRelatedInfo(roleId int) ([]*RelatedInfo, error)
 	AddRelations(roleId int, resIds []int) (int, error)
 	UpdateRelations(roleId int, resIds []int) (int, error)
@@ -37,10 +38,12 @@ type ApiAuthService interface {
 }
 
 type ApiAuth struct {
-	ClientId     int64  // client id
-	ClientSecret string // client secret
-	RedirectUri  string // 回调uri
-	ApiHost      string // host
+	ClientId     int64   // client id
+	ClientSecret string  // client secret
+	RedirectUri  string  // 回调uri
+	ApiHost      string  // host
+	Metrics      Metrics // 接口耗时指标，为空时不记录
+	Logger       Logger  // 日志，输出前统一脱敏
 }
 
 type ApiConfig struct {
@@ -48,26 +51,14 @@ type ApiConfig struct {
 	ClientSecret string
 	RedirectUri  string
 	ApiHost      string
+	Metrics      Metrics
+	Logger       Logger
 }
 
 func NewApiAuth(config *ApiConfig) ApiAuthService {
-	if config == nil {
-		panic("sso service init failed: config counld not be nil")
-	}
-
-	var clientIdFormat int64
-
-	if number, err := strconv.ParseInt(config.ClientId, 10, 64); err != nil {
-		panic(fmt.Sprintf("sso service init failed: appId is invalid %s", config.ClientId))
-	} else {
-		clientIdFormat = number
-	}
-
-	apiAuth := &ApiAuth{
-		ClientId:     clientIdFormat,
-		ClientSecret: config.ClientSecret,
-		RedirectUri:  config.RedirectUri,
-		ApiHost:      config.ApiHost,
+	apiAuth, err := newApiAuth(config, false)
+	if err != nil {
+		panic(fmt.Sprintf("sso service init failed: %v", err))
 	}
 	return apiAuth
-}
\ No newline at end of file
+}
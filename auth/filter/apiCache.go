@@ -0,0 +1,433 @@
+package filter
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 缓存分类，写操作按分类失效
+const (
+	CACHE_CLIENT    = "client"
+	CACHE_RESOURCE  = "resource"
+	CACHE_ROLE      = "role"
+	CACHE_ROLE_USER = "roleUser"
+	CACHE_RELATION  = "relation"
+)
+
+// 缓存命中统计
+type CacheStats struct {
+	Hits          uint64 // 命中次数
+	Misses        uint64 // 未命中并实际请求接口的次数
+	Shared        uint64 // 未命中但合并到进行中请求的次数
+	Invalidations uint64 // 写操作引起的失效次数
+}
+
+var _ ApiAuthService = (*CachedApiAuth)(nil)
+
+type cacheEntry struct {
+	category string
+	value    interface{}
+	expire   time.Time
+}
+
+// ApiAuthService的读缓存装饰器
+// 读操作结果按分类缓存，相同参数的并发读只请求一次接口；写操作完成后使受影响分类的缓存全部失效
+// 每次返回的都是缓存值的副本，调用方修改结果不会影响缓存和其他调用方
+type CachedApiAuth struct {
+	inner ApiAuthService
+	ttl   time.Duration
+	ttls  map[string]time.Duration
+
+	mu          sync.Mutex
+	entries     map[string]*cacheEntry
+	generations map[string]uint64
+	group       callGroup
+
+	hits          uint64
+	misses        uint64
+	shared        uint64
+	invalidations uint64
+}
+
+// ttl为各分类默认的缓存时间，可通过SetTTL单独调整
+func NewCachedApiAuth(inner ApiAuthService, ttl time.Duration) *CachedApiAuth {
+	if inner == nil {
+		panic("cached api auth init failed: inner service counld not be nil")
+	}
+	return &CachedApiAuth{
+		inner:       inner,
+		ttl:         ttl,
+		ttls:        make(map[string]time.Duration),
+		entries:     make(map[string]*cacheEntry),
+		generations: make(map[string]uint64),
+	}
+}
+
+// 设置某一分类的缓存时间，<=0表示该分类不缓存
+func (c *CachedApiAuth) SetTTL(category string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttls[category] = ttl
+}
+
+// 当前命中统计
+func (c *CachedApiAuth) Stats() CacheStats {
+	return CacheStats{
+		Hits:          atomic.LoadUint64(&c.hits),
+		Misses:        atomic.LoadUint64(&c.misses),
+		Shared:        atomic.LoadUint64(&c.shared),
+		Invalidations: atomic.LoadUint64(&c.invalidations),
+	}
+}
+
+// 使指定分类的缓存失效，不传分类时清空全部缓存
+func (c *CachedApiAuth) Invalidate(categories ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(categories) == 0 {
+		categories = []string{CACHE_CLIENT, CACHE_RESOURCE, CACHE_ROLE, CACHE_ROLE_USER, CACHE_RELATION}
+	}
+	for _, category := range categories {
+		c.generations[category]++
+	}
+	for k, e := range c.entries {
+		for _, category := range categories {
+			if e.category == category {
+				delete(c.entries, k)
+				break
+			}
+		}
+	}
+	atomic.AddUint64(&c.invalidations, 1)
+}
+
+func (c *CachedApiAuth) ttlOf(category string) time.Duration {
+	if ttl, ok := c.ttls[category]; ok {
+		return ttl
+	}
+	return c.ttl
+}
+
+// 读取缓存，未命中时调用load并写入缓存
+// 若load执行期间该分类被失效，结果只返回给调用者而不写入缓存，避免缓存写操作之前读到的旧数据
+func (c *CachedApiAuth) get(category, key string, load func() (interface{}, error)) (interface{}, error) {
+	key = category + ":" + key
+	now := time.Now()
+	c.mu.Lock()
+	ttl := c.ttlOf(category)
+	if e, ok := c.entries[key]; ok {
+		if now.Before(e.expire) {
+			c.mu.Unlock()
+			atomic.AddUint64(&c.hits, 1)
+			return cloneValue(e.value), nil
+		}
+		delete(c.entries, key)
+	}
+	generation := c.generations[category]
+	c.mu.Unlock()
+
+	// 合并的key包含分类的版本，失效后发起的读不会合并到失效前开始的加载
+	value, err, shared := c.group.do(key+"@"+strconv.FormatUint(generation, 10), func() (interface{}, error) {
+		value, err := load()
+		if err != nil || ttl <= 0 {
+			return value, err
+		}
+		c.mu.Lock()
+		if c.generations[category] == generation {
+			c.entries[key] = &cacheEntry{category: category, value: value, expire: time.Now().Add(ttl)}
+		}
+		c.mu.Unlock()
+		return value, nil
+	})
+	if shared {
+		atomic.AddUint64(&c.shared, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cloneValue(value), nil
+}
+
+// 深拷贝接口返回的指针、slice、map和struct
+func cloneValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return deepCopy(reflect.ValueOf(v)).Interface()
+}
+
+func deepCopy(src reflect.Value) reflect.Value {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return src
+		}
+		dst := reflect.New(src.Type().Elem())
+		dst.Elem().Set(deepCopy(src.Elem()))
+		return dst
+	case reflect.Interface:
+		if src.IsNil() {
+			return src
+		}
+		dst := reflect.New(src.Type()).Elem()
+		dst.Set(deepCopy(src.Elem()))
+		return dst
+	case reflect.Slice:
+		if src.IsNil() {
+			return src
+		}
+		dst := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			dst.Index(i).Set(deepCopy(src.Index(i)))
+		}
+		return dst
+	case reflect.Map:
+		if src.IsNil() {
+			return src
+		}
+		dst := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			dst.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return dst
+	case reflect.Struct:
+		dst := reflect.New(src.Type()).Elem()
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if f := dst.Field(i); f.CanSet() {
+				f.Set(deepCopy(src.Field(i)))
+			}
+		}
+		return dst
+	default:
+		return src
+	}
+}
+
+func (c *CachedApiAuth) GetClientById(id int) (*Client, error) {
+	v, err := c.get(CACHE_CLIENT, "id:"+strconv.Itoa(id), func() (interface{}, error) {
+		return c.inner.GetClientById(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Client), nil
+}
+
+func (c *CachedApiAuth) GetClientByUser(userId, roleType string) ([]*UserClient, error) {
+	v, err := c.get(CACHE_CLIENT, "user:"+userId+":"+roleType, func() (interface{}, error) {
+		return c.inner.GetClientByUser(userId, roleType)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*UserClient), nil
+}
+
+func (c *CachedApiAuth) UpdateClient(fullname, redirectUri string) (*ClientInfo, error) {
+	defer c.Invalidate(CACHE_CLIENT)
+	return c.inner.UpdateClient(fullname, redirectUri)
+}
+
+func (c *CachedApiAuth) GetAllResources() ([]*ApiResource, error) {
+	v, err := c.get(CACHE_RESOURCE, "all", func() (interface{}, error) {
+		return c.inner.GetAllResources()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*ApiResource), nil
+}
+
+func (c *CachedApiAuth) ListResources(opts ListOptions) (*ResourcePage, error) {
+	v, err := c.get(CACHE_RESOURCE, "list:"+opts.values().Encode(), func() (interface{}, error) {
+		return c.inner.ListResources(opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*ResourcePage), nil
+}
+
+func (c *CachedApiAuth) GetUserResources(userId string) ([]*ApiResource, error) {
+	v, err := c.get(CACHE_RESOURCE, "user:"+userId, func() (interface{}, error) {
+		return c.inner.GetUserResources(userId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*ApiResource), nil
+}
+
+func (c *CachedApiAuth) AddResource(resources []ResourceInfo) ([]int, error) {
+	defer c.Invalidate(CACHE_RESOURCE, CACHE_ROLE)
+	return c.inner.AddResource(resources)
+}
+
+func (c *CachedApiAuth) UpdateResource(rId int, rName, rDescription, rData string) (*ApiResource, error) {
+	defer c.Invalidate(CACHE_RESOURCE, CACHE_ROLE)
+	return c.inner.UpdateResource(rId, rName, rDescription, rData)
+}
+
+func (c *CachedApiAuth) DeleteResources(resourceIds []int) (*DeleteResInfo, error) {
+	defer c.Invalidate(CACHE_RESOURCE, CACHE_ROLE, CACHE_RELATION)
+	return c.inner.DeleteResources(resourceIds)
+}
+
+func (c *CachedApiAuth) GetRoleTree(relatedResource, relatedUser bool) ([]*RoleTree, error) {
+	key := fmt.Sprintf("tree:%t:%t", relatedResource, relatedUser)
+	v, err := c.get(CACHE_ROLE, key, func() (interface{}, error) {
+		return c.inner.GetRoleTree(relatedResource, relatedUser)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*RoleTree), nil
+}
+
+func (c *CachedApiAuth) GetUserRoleTree(userId string, relatedResource, relatedUser bool) ([]*UserRoleTree, error) {
+	key := fmt.Sprintf("userTree:%s:%t:%t", userId, relatedResource, relatedUser)
+	v, err := c.get(CACHE_ROLE, key, func() (interface{}, error) {
+		return c.inner.GetUserRoleTree(userId, relatedResource, relatedUser)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*UserRoleTree), nil
+}
+
+func (c *CachedApiAuth) GetAllRole(relatedResource, relatedUser bool) ([]*Role, error) {
+	key := fmt.Sprintf("all:%t:%t", relatedResource, relatedUser)
+	v, err := c.get(CACHE_ROLE, key, func() (interface{}, error) {
+		return c.inner.GetAllRole(relatedResource, relatedUser)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*Role), nil
+}
+
+func (c *CachedApiAuth) ListRoles(relatedResource, relatedUser bool, opts ListOptions) (*RolePage, error) {
+	key := fmt.Sprintf("list:%t:%t:%s", relatedResource, relatedUser, opts.values().Encode())
+	v, err := c.get(CACHE_ROLE, key, func() (interface{}, error) {
+		return c.inner.ListRoles(relatedResource, relatedUser, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*RolePage), nil
+}
+
+func (c *CachedApiAuth) GetUserRoles(userId string, isAll, relatedResource, relatedUser bool) ([]*UserRole, error) {
+	key := fmt.Sprintf("user:%s:%t:%t:%t", userId, isAll, relatedResource, relatedUser)
+	v, err := c.get(CACHE_ROLE, key, func() (interface{}, error) {
+		return c.inner.GetUserRoles(userId, isAll, relatedResource, relatedUser)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*UserRole), nil
+}
+
+func (c *CachedApiAuth) AddRole(name, description string, parentId int) (int, error) {
+	defer c.Invalidate(CACHE_ROLE, CACHE_CLIENT)
+	return c.inner.AddRole(name, description, parentId)
+}
+
+func (c *CachedApiAuth) UpdateRole(roleId int, name, description string, parentId int) (*Role, error) {
+	defer c.Invalidate(CACHE_ROLE, CACHE_CLIENT, CACHE_RESOURCE)
+	return c.inner.UpdateRole(roleId, name, description, parentId)
+}
+
+func (c *CachedApiAuth) DeleteRole(roleId int) (*DeleteRoleInfo, error) {
+	defer c.Invalidate(CACHE_ROLE, CACHE_ROLE_USER, CACHE_RELATION, CACHE_CLIENT, CACHE_RESOURCE)
+	return c.inner.DeleteRole(roleId)
+}
+
+func (c *CachedApiAuth) GetUsersOfRole(roleId int) ([]*RoleUser, error) {
+	v, err := c.get(CACHE_ROLE_USER, strconv.Itoa(roleId), func() (interface{}, error) {
+		return c.inner.GetUsersOfRole(roleId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*RoleUser), nil
+}
+
+func (c *CachedApiAuth) ListUsersOfRole(roleId int, opts ListOptions) (*RoleUserPage, error) {
+	key := strconv.Itoa(roleId) + ":list:" + opts.values().Encode()
+	v, err := c.get(CACHE_ROLE_USER, key, func() (interface{}, error) {
+		return c.inner.ListUsersOfRole(roleId, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*RoleUserPage), nil
+}
+
+func (c *CachedApiAuth) AddUserToRole(roleId int, infos []UserInfo) (int, error) {
+	defer c.Invalidate(CACHE_ROLE_USER, CACHE_ROLE, CACHE_CLIENT, CACHE_RESOURCE)
+	return c.inner.AddUserToRole(roleId, infos)
+}
+
+func (c *CachedApiAuth) UpdateUserOfRole(roleId int, info UserInfo) (*RoleUser, error) {
+	defer c.Invalidate(CACHE_ROLE_USER, CACHE_ROLE, CACHE_CLIENT)
+	return c.inner.UpdateUserOfRole(roleId, info)
+}
+
+func (c *CachedApiAuth) DeleteUserFromRole(roleId int, names []string) (int, error) {
+	defer c.Invalidate(CACHE_ROLE_USER, CACHE_ROLE, CACHE_CLIENT, CACHE_RESOURCE)
+	return c.inner.DeleteUserFromRole(roleId, names)
+}
+
+func (c *CachedApiAuth) GetAllRelatedInfo() ([]*RelatedInfo, error) {
+	v, err := c.get(CACHE_RELATION, "all", func() (interface{}, error) {
+		return c.inner.GetAllRelatedInfo()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*RelatedInfo), nil
+}
+
+func (c *CachedApiAuth) ListRelatedInfo(opts ListOptions) (*RelatedInfoPage, error) {
+	v, err := c.get(CACHE_RELATION, "list:"+opts.values().Encode(), func() (interface{}, error) {
+		return c.inner.ListRelatedInfo(opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*RelatedInfoPage), nil
+}
+
+func (c *CachedApiAuth) GetRelatedInfo(roleId int) ([]*RelatedInfo, error) {
+	v, err := c.get(CACHE_RELATION, strconv.Itoa(roleId), func() (interface{}, error) {
+		return c.inner.GetRelatedInfo(roleId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*RelatedInfo), nil
+}
+
+func (c *CachedApiAuth) AddRelations(roleId int, resIds []int) (int, error) {
+	defer c.Invalidate(CACHE_RELATION, CACHE_ROLE, CACHE_RESOURCE)
+	return c.inner.AddRelations(roleId, resIds)
+}
+
+func (c *CachedApiAuth) UpdateRelations(roleId int, resIds []int) (int, error) {
+	defer c.Invalidate(CACHE_RELATION, CACHE_ROLE, CACHE_RESOURCE)
+	return c.inner.UpdateRelations(roleId, resIds)
+}
+
+func (c *CachedApiAuth) DeleteRelations(roleId int, resIds []int) (int, error) {
+	defer c.Invalidate(CACHE_RELATION, CACHE_ROLE, CACHE_RESOURCE)
+	return c.inner.DeleteRelations(roleId, resIds)
+}
@@ -0,0 +1,101 @@
+package filter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// 第一次GetAllResources阻塞到release关闭，用于模拟进行中的慢请求
+type slowResourceApi struct {
+	ApiAuthService
+	mu      sync.Mutex
+	data    string
+	calls   int
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowResourceApi) GetAllResources() ([]*ApiResource, error) {
+	s.mu.Lock()
+	s.calls++
+	first := s.calls == 1
+	data := s.data
+	s.mu.Unlock()
+	if first {
+		close(s.started)
+		<-s.release
+	}
+	return []*ApiResource{{Id: 1, Data: data}}, nil
+}
+
+func (s *slowResourceApi) AddResource(resources []ResourceInfo) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = resources[0].Data
+	return []int{1}, nil
+}
+
+func TestCachedApiAuthWriteDuringLoad(t *testing.T) {
+	api := &slowResourceApi{data: "old", started: make(chan struct{}), release: make(chan struct{})}
+	cache := NewCachedApiAuth(api, time.Minute)
+
+	stale := make(chan string)
+	go func() {
+		resources, err := cache.GetAllResources()
+		if err != nil {
+			t.Error(err)
+		}
+		stale <- resources[0].Data
+	}()
+	<-api.started
+	if _, err := cache.AddResource([]ResourceInfo{{Data: "new"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		read func() string
+		want string
+	}{
+		{"read after write", func() string {
+			// 合并到失效前的加载时会一直等待release
+			done := make(chan string, 1)
+			go func() {
+				resources, _ := cache.GetAllResources()
+				done <- resources[0].Data
+			}()
+			select {
+			case data := <-done:
+				return data
+			case <-time.After(time.Second):
+				return "joined stale load"
+			}
+		}, "new"},
+		{"load started before write", func() string {
+			close(api.release)
+			return <-stale
+		}, "old"},
+		{"not cached from stale load", func() string {
+			resources, _ := cache.GetAllResources()
+			return resources[0].Data
+		}, "new"},
+	}
+	for _, c := range cases {
+		if got := c.read(); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCachedApiAuthReturnsCopies(t *testing.T) {
+	api := &slowResourceApi{data: "a", started: make(chan struct{}), release: make(chan struct{})}
+	close(api.release)
+	cache := NewCachedApiAuth(api, time.Minute)
+	first, _ := cache.GetAllResources()
+	first[0].Data = "modified"
+	second, _ := cache.GetAllResources()
+	if second[0].Data != "a" {
+		t.Fatalf("cached value modified by caller: %q", second[0].Data)
+	}
+}
@@ -0,0 +1,274 @@
+package filter
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/astaxie/beego/context"
+	"github.com/tongwu13/golang_common/beego/i18n"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HEADER_API_KEY = "X-Api-Key" // 默认的api key请求头
+	API_KEY_PREFIX = "ak_"
+)
+
+var (
+	ErrApiKeyNotFound = errors.New("api key not found")
+	ErrApiKeyInvalid  = errors.New("api key is invalid")
+	ErrApiKeyExpired  = errors.New("api key is expired")
+	ErrApiKeyRevoked  = errors.New("api key is revoked")
+)
+
+// 机器调用方（定时任务、合作方等）的api key，只保存key的sha256
+type ApiKey struct {
+	Id        string    `json:"id"`
+	Hash      string    `json:"hash"`
+	Principal string    `json:"principal"` // 调用方名称，作为User.Id
+	Resources []string  `json:"resources"` // 授予的资源Data，与UrlControl中的规则对应
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"` // 为零值时不过期
+	RevokedAt time.Time `json:"revokedAt"` // 为零值时未吊销
+}
+
+func (k *ApiKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+func (k *ApiKey) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// 转换为User，CheckAuthorityFilter和CurrentUser与sso登录的用户一致处理
+func (k *ApiKey) User() User {
+	resources := make([]*Resource, 0, len(k.Resources))
+	for _, data := range k.Resources {
+		resources = append(resources, &Resource{Data: data})
+	}
+	user := User{Id: k.Principal, Fullname: k.Principal, ApiKeyId: k.Id}
+	user.setResources(resources)
+	return user
+}
+
+// api key的存储，可替换为数据库等实现
+type ApiKeyStore interface {
+	Save(key *ApiKey) error
+	Get(id string) (*ApiKey, error)
+	GetByHash(hash string) (*ApiKey, error)
+	List(principal string) ([]*ApiKey, error) // principal为空时返回全部
+}
+
+// ApiKeyStore的内存实现
+type MemoryApiKeyStore struct {
+	mu     sync.RWMutex
+	keys   map[string]*ApiKey // id - key
+	hashes map[string]string  // hash - id
+}
+
+var _ ApiKeyStore = (*MemoryApiKeyStore)(nil)
+
+func NewMemoryApiKeyStore() *MemoryApiKeyStore {
+	return &MemoryApiKeyStore{keys: make(map[string]*ApiKey), hashes: make(map[string]string)}
+}
+
+func (s *MemoryApiKeyStore) Save(key *ApiKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := *key
+	if old, ok := s.keys[k.Id]; ok {
+		delete(s.hashes, old.Hash)
+	}
+	s.keys[k.Id] = &k
+	s.hashes[k.Hash] = k.Id
+	return nil
+}
+
+func (s *MemoryApiKeyStore) Get(id string) (*ApiKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, ErrApiKeyNotFound
+	}
+	key := *k
+	return &key, nil
+}
+
+func (s *MemoryApiKeyStore) GetByHash(hash string) (*ApiKey, error) {
+	s.mu.RLock()
+	id, ok := s.hashes[hash]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrApiKeyNotFound
+	}
+	return s.Get(id)
+}
+
+func (s *MemoryApiKeyStore) List(principal string) ([]*ApiKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]*ApiKey, 0)
+	for _, k := range s.keys {
+		if principal == "" || k.Principal == principal {
+			key := *k
+			keys = append(keys, &key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// api key的创建、轮换、吊销及校验
+type ApiKeyManager struct {
+	store ApiKeyStore
+	now   func() time.Time
+}
+
+func NewApiKeyManager(store ApiKeyStore) *ApiKeyManager {
+	if store == nil {
+		store = NewMemoryApiKeyStore()
+	}
+	return &ApiKeyManager{store: store, now: time.Now}
+}
+
+func hashApiKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 创建api key，ttl为0时不过期；返回的明文key只在创建时可见
+func (m *ApiKeyManager) Create(principal string, resources []string, ttl time.Duration) (string, *ApiKey, error) {
+	if principal == "" {
+		return "", nil, errors.New("api key principal is empty")
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", nil, err
+	}
+	raw := API_KEY_PREFIX + id + "." + secret
+	now := m.now()
+	key := &ApiKey{
+		Id:        id,
+		Hash:      hashApiKey(raw),
+		Principal: principal,
+		Resources: append([]string(nil), resources...),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		key.ExpiresAt = now.Add(ttl)
+	}
+	if err := m.store.Save(key); err != nil {
+		return "", nil, err
+	}
+	return raw, key, nil
+}
+
+// 轮换api key：创建同principal、同资源的新key，旧key在grace之后过期（grace为0时立即失效）
+// 新key的有效期与旧key创建时的有效期相同
+func (m *ApiKeyManager) Rotate(id string, grace time.Duration) (string, *ApiKey, error) {
+	old, err := m.store.Get(id)
+	if err != nil {
+		return "", nil, err
+	}
+	if old.Revoked() {
+		return "", nil, ErrApiKeyRevoked
+	}
+	var ttl time.Duration
+	if !old.ExpiresAt.IsZero() {
+		ttl = old.ExpiresAt.Sub(old.CreatedAt)
+	}
+	raw, key, err := m.Create(old.Principal, old.Resources, ttl)
+	if err != nil {
+		return "", nil, err
+	}
+	expiresAt := m.now().Add(grace)
+	if old.ExpiresAt.IsZero() || expiresAt.Before(old.ExpiresAt) {
+		old.ExpiresAt = expiresAt
+	}
+	if err := m.store.Save(old); err != nil {
+		return "", nil, err
+	}
+	return raw, key, nil
+}
+
+// 吊销api key，立即失效
+func (m *ApiKeyManager) Revoke(id string) error {
+	key, err := m.store.Get(id)
+	if err != nil {
+		return err
+	}
+	if key.Revoked() {
+		return nil
+	}
+	key.RevokedAt = m.now()
+	return m.store.Save(key)
+}
+
+func (m *ApiKeyManager) List(principal string) ([]*ApiKey, error) {
+	return m.store.List(principal)
+}
+
+// 校验明文key，返回对应的ApiKey
+func (m *ApiKeyManager) Authenticate(raw string) (*ApiKey, error) {
+	if !strings.HasPrefix(raw, API_KEY_PREFIX) {
+		return nil, ErrApiKeyInvalid
+	}
+	key, err := m.store.GetByHash(hashApiKey(raw))
+	if err == ErrApiKeyNotFound {
+		return nil, ErrApiKeyInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	if key.Revoked() {
+		return nil, ErrApiKeyRevoked
+	}
+	if key.Expired(m.now()) {
+		return nil, ErrApiKeyExpired
+	}
+	return key, nil
+}
+
+func (a *Auth) apiKeyHeader() string {
+	if a.ApiKeyHeader == "" {
+		return HEADER_API_KEY
+	}
+	return a.ApiKeyHeader
+}
+
+// 请求头中带有api key时校验并写入请求上下文，返回是否已处理该请求
+func (a *Auth) checkApiKey(ctx *context.Context) bool {
+	if a.ApiKeys == nil {
+		return false
+	}
+	raw := ctx.Input.Header(a.apiKeyHeader())
+	if raw == "" {
+		return false
+	}
+	key, err := a.ApiKeys.Authenticate(raw)
+	if err != nil {
+		a.log().Warn("api key rejected", F("error", err))
+		a.audit(ctx, &AuditEvent{Type: AUDIT_LOGIN_FAILURE, Reason: err.Error()})
+		ctx.ResponseWriter.WriteHeader(401)
+		ctx.WriteString(i18n.T(ctx, i18n.MSG_API_KEY_INVALID))
+		return true
+	}
+	ctx.Input.SetData(DATA_KEY_USER, key.User())
+	return true
+}
@@ -4,9 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/astaxie/beego/httplib"
-	"github.com/astaxie/beego/logs"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 )
 
@@ -39,7 +39,7 @@ func (a *ApiAuth) GetClientById(id int) (*Client, error) {
 	resp := httplib.Get(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +51,7 @@ func (a *ApiAuth) GetClientById(id int) (*Client, error) {
 	if err = json.Unmarshal(data, &client); err != nil {
 		return nil, err
 	}
-	logs.Info(client)
+	a.log().Debug("client loaded", F("client", client))
 	return &client, nil
 }
 
@@ -64,11 +64,14 @@ type UserClient struct {
 
 // 查询某用户在指定类型角色下所在的Client
 func (a *ApiAuth) GetClientByUser(userId, roleType string) ([]*UserClient, error) {
-	url := a.ApiHost + "/api/userClients?user_id=" + userId + "&role_type=" + roleType
+	params := url.Values{}
+	params.Set("user_id", userId)
+	params.Set("role_type", roleType)
+	url := a.ApiHost + "/api/userClients?" + params.Encode()
 	resp := httplib.Get(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +106,7 @@ func (a *ApiAuth) UpdateClient(fullname, redirectUri string) (*ClientInfo, error
 	if err != nil {
 		return nil, err
 	}
-	response, err := resp.Body(b).Response()
+	response, err := a.send(resp.Body(b))
 	if err != nil {
 		return nil, err
 	}
@@ -137,7 +140,10 @@ func (a *ApiAuth) GetAllResources() ([]*ApiResource, error) {
 	resp := httplib.Get(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
+	if err != nil {
+		return nil, err
+	}
 	data, err := processResp(response)
 	if err != nil {
 		return nil, err
@@ -151,11 +157,14 @@ func (a *ApiAuth) GetAllResources() ([]*ApiResource, error) {
 
 // 查看用户在Client下的全部资源
 func (a *ApiAuth) GetUserResources(userId string) ([]*ApiResource, error) {
-	url := a.ApiHost + "/api/resources?user_id=" + userId
+	url := a.ApiHost + "/api/resources?user_id=" + url.QueryEscape(userId)
 	resp := httplib.Get(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
+	if err != nil {
+		return nil, err
+	}
 	data, err := processResp(response)
 	if err != nil {
 		return nil, err
@@ -184,7 +193,7 @@ func (a *ApiAuth) AddResource(resources []ResourceInfo) ([]int, error) {
 	if err != nil {
 		return nil, err
 	}
-	response, err := resp.Body(b).Response()
+	response, err := a.send(resp.Body(b))
 	if err != nil {
 		return nil, err
 	}
@@ -214,7 +223,7 @@ func (a *ApiAuth) UpdateResource(rId int, rName, rDescription, rData string) (*A
 	resp := httplib.Put(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Body(b).Response()
+	response, err := a.send(resp.Body(b))
 	if err != nil {
 		return nil, err
 	}
@@ -247,7 +256,7 @@ func (a *ApiAuth) DeleteResources(resourceIds []int) (*DeleteResInfo, error) {
 	resp := httplib.Delete(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -276,6 +285,14 @@ type Role struct {
 	Users       []*UserOfRole  `json:"users"`       // 角色相关用户
 }
 
+// 角色查询接口的relate_resource、relate_user参数
+func relateParams(relatedResource, relatedUser bool) url.Values {
+	params := url.Values{}
+	params.Set("relate_resource", strconv.FormatBool(relatedResource))
+	params.Set("relate_user", strconv.FormatBool(relatedUser))
+	return params
+}
+
 // 用户角色
 type UserRole struct {
 	Id          int           `json:"id"`          // 角色id
@@ -299,6 +316,7 @@ type RoleTree struct {
 	ParentId    int           `json:"parent_id"`   // 父角色id
 	Created     string        `json:"created"`     // 创建时间
 	Updated     string        `json:"updated"`     // 更新时间
+	RoleType    string        `json:"role_type"`   // 角色类型
 	Resources   []*Resource   `json:"resources"`   // 角色相关资源
 	Users       []*UserOfRole `json:"users"`       // 角色相关用户
 	Children    []*RoleTree   `json:"children"`    // 拥有该角色的用户
@@ -329,11 +347,13 @@ type UserOfRole struct {
 
 // 查询角色树
 func (a *ApiAuth) GetRoleTree(relatedResource, relatedUser bool) ([]*RoleTree, error) {
-	url := a.ApiHost + "/api/roles?is_tree=true&relate_user=" + strconv.FormatBool(relatedUser) + "relate_resource=" + strconv.FormatBool(relatedResource)
+	params := relateParams(relatedResource, relatedUser)
+	params.Set("is_tree", "true")
+	url := a.ApiHost + "/api/roles?" + params.Encode()
 	resp := httplib.Get(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -350,11 +370,15 @@ func (a *ApiAuth) GetRoleTree(relatedResource, relatedUser bool) ([]*RoleTree, e
 
 // 查询指定用户的角色树
 func (a *ApiAuth) GetUserRoleTree(userId string, relatedResource, relatedUser bool) ([]*UserRoleTree, error) {
-	url := a.ApiHost + "/api/userRoles?is_tree=true&is_all=true&user_id=" + userId + "&relate_user=" + strconv.FormatBool(relatedUser) + "relate_resource=" + strconv.FormatBool(relatedResource)
+	params := relateParams(relatedResource, relatedUser)
+	params.Set("is_tree", "true")
+	params.Set("is_all", "true")
+	params.Set("user_id", userId)
+	url := a.ApiHost + "/api/userRoles?" + params.Encode()
 	resp := httplib.Get(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -366,13 +390,13 @@ func (a *ApiAuth) GetUserRoleTree(userId string, relatedResource, relatedUser bo
 	return userRoleTree, err
 }
 
-// 查询全部角色
+// 查询全部角色，返回不分层级的列表，层级见ParentId
 func (a *ApiAuth) GetAllRole(relatedResource, relatedUser bool) ([]*Role, error) {
-	url := a.ApiHost + "/api/roles?is_tree=true&relate_user=" + strconv.FormatBool(relatedUser) + "relate_resource=" + strconv.FormatBool(relatedResource)
+	url := a.ApiHost + "/api/roles?" + relateParams(relatedResource, relatedUser).Encode()
 	resp := httplib.Get(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -389,11 +413,14 @@ func (a *ApiAuth) GetAllRole(relatedResource, relatedUser bool) ([]*Role, error)
 
 // 查询指定用户角色（直接关联的或全部）
 func (a *ApiAuth) GetUserRoles(userId string, isAll, relatedResource, relatedUser bool) ([]*UserRole, error) {
-	url := a.ApiHost + "/api/userRoles?is_all=" + strconv.FormatBool(isAll) + "&user_id=" + userId + "&relate_user=" + strconv.FormatBool(relatedUser) + "relate_resource=" + strconv.FormatBool(relatedResource)
+	params := relateParams(relatedResource, relatedUser)
+	params.Set("is_all", strconv.FormatBool(isAll))
+	params.Set("user_id", userId)
+	url := a.ApiHost + "/api/userRoles?" + params.Encode()
 	resp := httplib.Get(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -419,7 +446,7 @@ func (a *ApiAuth) AddRole(name string, description string, parentId int) (int, e
 	resp := httplib.Post(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Body(b).Response()
+	response, err := a.send(resp.Body(b))
 	if err != nil {
 		return -1, err
 	}
@@ -449,7 +476,7 @@ func (a *ApiAuth) UpdateRole(roleId int, name, description string, parentId int)
 	resp := httplib.Put(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Body(b).Response()
+	response, err := a.send(resp.Body(b))
 	if err != nil {
 		return nil, err
 	}
@@ -477,7 +504,7 @@ func (a *ApiAuth) DeleteRole(roleId int) (*DeleteRoleInfo, error) {
 	resp := httplib.Delete(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -511,7 +538,7 @@ func (a *ApiAuth) GetUsersOfRole(roleId int) ([]*RoleUser, error) {
 	resp := httplib.Get(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -536,7 +563,7 @@ func (a *ApiAuth) AddUserToRole(roleId int, infos []UserInfo) (int, error) {
 	if err != nil {
 		return -1, err
 	}
-	response, err := resp.Body(b).Response()
+	response, err := a.send(resp.Body(b))
 	if err != nil {
 		return -1, err
 	}
@@ -561,7 +588,7 @@ func (a *ApiAuth) UpdateUserOfRole(roleId int, info UserInfo) (*RoleUser, error)
 	if err != nil {
 		return nil, err
 	}
-	response, err := resp.Body(b).Response()
+	response, err := a.send(resp.Body(b))
 	if err != nil {
 		return nil, err
 	}
@@ -586,9 +613,9 @@ func (a *ApiAuth) DeleteUserFromRole(roleId int, names []string) (int, error) {
 	if err != nil {
 		return -1, err
 	}
-	response, err := resp.Body(b).Response()
+	response, err := a.send(resp.Body(b))
 	if err != nil {
-		return -1, nil
+		return -1, err
 	}
 	data, err := processResp(response)
 	if err != nil {
@@ -613,7 +640,7 @@ func (a *ApiAuth) GetAllRelatedInfo() ([]*RelatedInfo, error) {
 	resp := httplib.Get(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -634,7 +661,7 @@ func (a *ApiAuth) GetRelatedInfo(roleId int) ([]*RelatedInfo, error) {
 	resp := httplib.Get(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Response()
+	response, err := a.send(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -659,7 +686,7 @@ func (a *ApiAuth) AddRelations(roleId int, resIds []int) (int, error) {
 	resp := httplib.Post(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Body(b).Response()
+	response, err := a.send(resp.Body(b))
 	if err != nil {
 		return -1, err
 	}
@@ -684,7 +711,7 @@ func (a *ApiAuth) UpdateRelations(roleId int, resIds []int) (int, error) {
 	resp := httplib.Put(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Body(b).Response()
+	response, err := a.send(resp.Body(b))
 	if err != nil {
 		return -1, err
 	}
@@ -709,7 +736,7 @@ func (a *ApiAuth) DeleteRelations(roleId int, resIds []int) (int, error) {
 	resp := httplib.Delete(url)
 	resp.Header("client-secret", a.ClientSecret)
 	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
-	response, err := resp.Body(b).Response()
+	response, err := a.send(resp.Body(b))
 	if err != nil {
 		return -1, err
 	}
@@ -726,7 +753,6 @@ func (a *ApiAuth) DeleteRelations(roleId int, resIds []int) (int, error) {
 
 // 统一对接口返回结果进行处理，将有效数据部分序列化后返回
 func processResp(response *http.Response) (data []byte, err error) {
-	logs.Info(response.StatusCode)
 	if response.StatusCode != http.StatusOK {
 		return nil, errors.New("unexpected status code of " + strconv.Itoa(response.StatusCode))
 	}
@@ -0,0 +1,361 @@
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/astaxie/beego/httplib"
+	"net/url"
+	"strconv"
+)
+
+// 未指定每页数量时使用的值；接口只在带page_size时按分页格式返回，不带时返回旧的全部结果
+const DEFAULT_PAGE_SIZE = 100
+
+// 游标没有前进时返回，避免接口重复返回相同游标时无限循环
+var ErrCursorNotAdvanced = errors.New("list cursor did not advance")
+
+// 分页及过滤条件，除PageSize外零值字段不会传给接口
+type ListOptions struct {
+	PageSize     int    // 每页数量，<=0时使用DEFAULT_PAGE_SIZE
+	Cursor       string // 分页游标，首页为空，后续页使用上一页返回的NextCursor
+	NamePrefix   string // 名称前缀（角色用户为用户Id前缀）
+	CreatedBy    string // 创建者
+	UpdatedSince string // 更新时间下限，格式与接口返回的时间一致
+}
+
+// 将分页及过滤条件转换为query参数
+func (o ListOptions) values() url.Values {
+	params := url.Values{}
+	pageSize := o.PageSize
+	if pageSize <= 0 {
+		pageSize = DEFAULT_PAGE_SIZE
+	}
+	params.Set("page_size", strconv.Itoa(pageSize))
+	if o.Cursor != "" {
+		params.Set("cursor", o.Cursor)
+	}
+	if o.NamePrefix != "" {
+		params.Set("name_prefix", o.NamePrefix)
+	}
+	if o.CreatedBy != "" {
+		params.Set("created_by", o.CreatedBy)
+	}
+	if o.UpdatedSince != "" {
+		params.Set("updated_since", o.UpdatedSince)
+	}
+	return params
+}
+
+// 分页查询资源的单页结果
+type ResourcePage struct {
+	Items      []*ApiResource `json:"items"`       // 当前页资源
+	NextCursor string         `json:"next_cursor"` // 下一页游标，为空表示已是最后一页
+}
+
+// 分页查询角色的单页结果
+type RolePage struct {
+	Items      []*Role `json:"items"`       // 当前页角色
+	NextCursor string  `json:"next_cursor"` // 下一页游标，为空表示已是最后一页
+}
+
+// 分页查询角色用户的单页结果
+type RoleUserPage struct {
+	Items      []*RoleUser `json:"items"`       // 当前页用户
+	NextCursor string      `json:"next_cursor"` // 下一页游标，为空表示已是最后一页
+}
+
+// 分页查询角色资源关联的单页结果
+type RelatedInfoPage struct {
+	Items      []*RelatedInfo `json:"items"`       // 当前页关联信息
+	NextCursor string         `json:"next_cursor"` // 下一页游标，为空表示已是最后一页
+}
+
+// 分页查看Client下资源
+func (a *ApiAuth) ListResources(opts ListOptions) (*ResourcePage, error) {
+	url := a.ApiHost + "/api/resources?" + opts.values().Encode()
+	resp := httplib.Get(url)
+	resp.Header("client-secret", a.ClientSecret)
+	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
+	response, err := a.send(resp)
+	if err != nil {
+		return nil, err
+	}
+	data, err := processResp(response)
+	if err != nil {
+		return nil, err
+	}
+	page := ResourcePage{}
+	if err = json.Unmarshal(data, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// 分页查询角色
+func (a *ApiAuth) ListRoles(relatedResource, relatedUser bool, opts ListOptions) (*RolePage, error) {
+	params := opts.values()
+	params.Set("relate_user", strconv.FormatBool(relatedUser))
+	params.Set("relate_resource", strconv.FormatBool(relatedResource))
+	url := a.ApiHost + "/api/roles?" + params.Encode()
+	resp := httplib.Get(url)
+	resp.Header("client-secret", a.ClientSecret)
+	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
+	response, err := a.send(resp)
+	if err != nil {
+		return nil, err
+	}
+	data, err := processResp(response)
+	if err != nil {
+		return nil, err
+	}
+	page := RolePage{}
+	if err = json.Unmarshal(data, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// 分页查询角色中的用户
+func (a *ApiAuth) ListUsersOfRole(roleId int, opts ListOptions) (*RoleUserPage, error) {
+	params := opts.values()
+	params.Set("role_id", strconv.Itoa(roleId))
+	url := a.ApiHost + "/api/roleUsers?" + params.Encode()
+	resp := httplib.Get(url)
+	resp.Header("client-secret", a.ClientSecret)
+	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
+	response, err := a.send(resp)
+	if err != nil {
+		return nil, err
+	}
+	data, err := processResp(response)
+	if err != nil {
+		return nil, err
+	}
+	page := RoleUserPage{}
+	if err = json.Unmarshal(data, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// 分页查看Client下角色资源关联
+func (a *ApiAuth) ListRelatedInfo(opts ListOptions) (*RelatedInfoPage, error) {
+	url := a.ApiHost + "/api/roleResources?" + opts.values().Encode()
+	resp := httplib.Get(url)
+	resp.Header("client-secret", a.ClientSecret)
+	resp.Header("client-id", strconv.FormatInt(a.ClientId, 10))
+	response, err := a.send(resp)
+	if err != nil {
+		return nil, err
+	}
+	data, err := processResp(response)
+	if err != nil {
+		return nil, err
+	}
+	page := RelatedInfoPage{}
+	if err = json.Unmarshal(data, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// 根据上一页和本页的游标判断是否已遍历完，游标没有前进时返回ErrCursorNotAdvanced
+func advance(cursor, next string) (bool, error) {
+	if next == "" {
+		return true, nil
+	}
+	if next == cursor {
+		return true, ErrCursorNotAdvanced
+	}
+	return false, nil
+}
+
+// 逐页遍历资源，只在当前页消费完后才请求下一页
+// 用法：
+//
+//	it := NewResourceIterator(api, ListOptions{PageSize: 500})
+//	for it.Next() {
+//		r := it.Value()
+//	}
+//	if err := it.Err(); err != nil {}
+type ResourceIterator struct {
+	api  ApiAuthService
+	opts ListOptions
+	buf  []*ApiResource
+	cur  *ApiResource
+	done bool
+	err  error
+}
+
+func NewResourceIterator(api ApiAuthService, opts ListOptions) *ResourceIterator {
+	return &ResourceIterator{api: api, opts: opts}
+}
+
+// 移动到下一条记录，没有更多记录或出错时返回false
+func (it *ResourceIterator) Next() bool {
+	for len(it.buf) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		page, err := it.api.ListResources(it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.buf = page.Items
+		if it.done, it.err = advance(it.opts.Cursor, page.NextCursor); it.err != nil {
+			return false
+		}
+		it.opts.Cursor = page.NextCursor
+	}
+	it.cur, it.buf = it.buf[0], it.buf[1:]
+	return true
+}
+
+// 当前记录
+func (it *ResourceIterator) Value() *ApiResource {
+	return it.cur
+}
+
+// 遍历过程中出现的错误
+func (it *ResourceIterator) Err() error {
+	return it.err
+}
+
+// 逐页遍历角色
+type RoleIterator struct {
+	api             ApiAuthService
+	relatedResource bool
+	relatedUser     bool
+	opts            ListOptions
+	buf             []*Role
+	cur             *Role
+	done            bool
+	err             error
+}
+
+func NewRoleIterator(api ApiAuthService, relatedResource, relatedUser bool, opts ListOptions) *RoleIterator {
+	return &RoleIterator{api: api, relatedResource: relatedResource, relatedUser: relatedUser, opts: opts}
+}
+
+// 移动到下一条记录，没有更多记录或出错时返回false
+func (it *RoleIterator) Next() bool {
+	for len(it.buf) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		page, err := it.api.ListRoles(it.relatedResource, it.relatedUser, it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.buf = page.Items
+		if it.done, it.err = advance(it.opts.Cursor, page.NextCursor); it.err != nil {
+			return false
+		}
+		it.opts.Cursor = page.NextCursor
+	}
+	it.cur, it.buf = it.buf[0], it.buf[1:]
+	return true
+}
+
+// 当前记录
+func (it *RoleIterator) Value() *Role {
+	return it.cur
+}
+
+// 遍历过程中出现的错误
+func (it *RoleIterator) Err() error {
+	return it.err
+}
+
+// 逐页遍历角色中的用户
+type RoleUserIterator struct {
+	api    ApiAuthService
+	roleId int
+	opts   ListOptions
+	buf    []*RoleUser
+	cur    *RoleUser
+	done   bool
+	err    error
+}
+
+func NewRoleUserIterator(api ApiAuthService, roleId int, opts ListOptions) *RoleUserIterator {
+	return &RoleUserIterator{api: api, roleId: roleId, opts: opts}
+}
+
+// 移动到下一条记录，没有更多记录或出错时返回false
+func (it *RoleUserIterator) Next() bool {
+	for len(it.buf) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		page, err := it.api.ListUsersOfRole(it.roleId, it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.buf = page.Items
+		if it.done, it.err = advance(it.opts.Cursor, page.NextCursor); it.err != nil {
+			return false
+		}
+		it.opts.Cursor = page.NextCursor
+	}
+	it.cur, it.buf = it.buf[0], it.buf[1:]
+	return true
+}
+
+// 当前记录
+func (it *RoleUserIterator) Value() *RoleUser {
+	return it.cur
+}
+
+// 遍历过程中出现的错误
+func (it *RoleUserIterator) Err() error {
+	return it.err
+}
+
+// 逐页遍历角色资源关联
+type RelatedInfoIterator struct {
+	api  ApiAuthService
+	opts ListOptions
+	buf  []*RelatedInfo
+	cur  *RelatedInfo
+	done bool
+	err  error
+}
+
+func NewRelatedInfoIterator(api ApiAuthService, opts ListOptions) *RelatedInfoIterator {
+	return &RelatedInfoIterator{api: api, opts: opts}
+}
+
+// 移动到下一条记录，没有更多记录或出错时返回false
+func (it *RelatedInfoIterator) Next() bool {
+	for len(it.buf) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		page, err := it.api.ListRelatedInfo(it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.buf = page.Items
+		if it.done, it.err = advance(it.opts.Cursor, page.NextCursor); it.err != nil {
+			return false
+		}
+		it.opts.Cursor = page.NextCursor
+	}
+	it.cur, it.buf = it.buf[0], it.buf[1:]
+	return true
+}
+
+// 当前记录
+func (it *RelatedInfoIterator) Value() *RelatedInfo {
+	return it.cur
+}
+
+// 遍历过程中出现的错误
+func (it *RelatedInfoIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,155 @@
+package filter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// 模拟sso的列表接口：不带page_size时按旧格式返回全部数据（数组），否则按cursor分页
+func newPagingServer(t *testing.T, total int, stuck bool) (*httptest.Server, *[]url.Values) {
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		queries = append(queries, q)
+		items := make([]map[string]interface{}, 0, total)
+		for i := 1; i <= total; i++ {
+			items = append(items, map[string]interface{}{"id": i, "name": "r" + strconv.Itoa(i), "role_id": i, "resource_id": i, "user_id": "u" + strconv.Itoa(i)})
+		}
+		var data interface{} = items
+		if q.Get("page_size") != "" {
+			size, _ := strconv.Atoi(q.Get("page_size"))
+			start, _ := strconv.Atoi(q.Get("cursor"))
+			end := start + size
+			next := ""
+			if end < total {
+				next = strconv.Itoa(end)
+			} else {
+				end = total
+			}
+			if stuck {
+				next = "0"
+			}
+			data = map[string]interface{}{"items": items[start:end], "next_cursor": next}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"res_code": SUCC, "data": data})
+	}))
+	return server, &queries
+}
+
+func newTestApiAuth(host string) *ApiAuth {
+	return NewApiAuth(&ApiConfig{ClientId: "1", ClientSecret: "secret", ApiHost: host}).(*ApiAuth)
+}
+
+func TestListOptionsValues(t *testing.T) {
+	cases := []struct {
+		opts ListOptions
+		want string
+	}{
+		{ListOptions{}, "page_size=100"},
+		{ListOptions{PageSize: -1}, "page_size=100"},
+		{ListOptions{PageSize: 20, Cursor: "abc"}, "cursor=abc&page_size=20"},
+		{ListOptions{NamePrefix: "ops", CreatedBy: "tom", UpdatedSince: "2020-01-01"}, "created_by=tom&name_prefix=ops&page_size=100&updated_since=2020-01-01"},
+	}
+	for _, c := range cases {
+		if got := c.opts.values().Encode(); got != c.want {
+			t.Errorf("%+v values = %q, want %q", c.opts, got, c.want)
+		}
+	}
+}
+
+func TestListWithZeroOptions(t *testing.T) {
+	server, _ := newPagingServer(t, 3, false)
+	defer server.Close()
+	api := newTestApiAuth(server.URL)
+	cases := []struct {
+		name string
+		list func() (int, error)
+	}{
+		{"resources", func() (int, error) { p, err := api.ListResources(ListOptions{}); return itemCount(p, err) }},
+		{"roles", func() (int, error) { p, err := api.ListRoles(false, false, ListOptions{}); return itemCount(p, err) }},
+		{"role users", func() (int, error) { p, err := api.ListUsersOfRole(1, ListOptions{}); return itemCount(p, err) }},
+		{"relations", func() (int, error) { p, err := api.ListRelatedInfo(ListOptions{}); return itemCount(p, err) }},
+	}
+	for _, c := range cases {
+		n, err := c.list()
+		if err != nil || n != 3 {
+			t.Errorf("%s: got %d items, error %v", c.name, n, err)
+		}
+	}
+}
+
+func itemCount(page interface{}, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	return reflect.ValueOf(page).Elem().FieldByName("Items").Len(), nil
+}
+
+func TestIterators(t *testing.T) {
+	cases := []struct {
+		name      string
+		total     int
+		stuck     bool
+		wantItems int
+		wantPages int
+		wantErr   error
+	}{
+		{"empty", 0, false, 0, 1, nil},
+		{"single page", 2, false, 2, 1, nil},
+		{"exact pages", 4, false, 4, 2, nil},
+		{"partial last page", 5, false, 5, 3, nil},
+		// 重复游标对应的页不再返回
+		{"cursor not advancing", 5, true, 2, 2, ErrCursorNotAdvanced},
+	}
+	for _, c := range cases {
+		server, queries := newPagingServer(t, c.total, c.stuck)
+		api := newTestApiAuth(server.URL)
+		iterators := map[string]func() (int, error){
+			"resources": func() (int, error) {
+				it := NewResourceIterator(api, ListOptions{PageSize: 2})
+				n := 0
+				for it.Next() {
+					n++
+				}
+				return n, it.Err()
+			},
+			"roles": func() (int, error) {
+				it := NewRoleIterator(api, false, false, ListOptions{PageSize: 2})
+				n := 0
+				for it.Next() {
+					n++
+				}
+				return n, it.Err()
+			},
+			"role users": func() (int, error) {
+				it := NewRoleUserIterator(api, 1, ListOptions{PageSize: 2})
+				n := 0
+				for it.Next() {
+					n++
+				}
+				return n, it.Err()
+			},
+			"relations": func() (int, error) {
+				it := NewRelatedInfoIterator(api, ListOptions{PageSize: 2})
+				n := 0
+				for it.Next() {
+					n++
+				}
+				return n, it.Err()
+			},
+		}
+		for name, iterate := range iterators {
+			*queries = nil
+			n, err := iterate()
+			if n != c.wantItems || err != c.wantErr || len(*queries) != c.wantPages {
+				t.Errorf("%s %s: %d items, %d pages, error %v; want %d, %d, %v", c.name, name, n, len(*queries), err, c.wantItems, c.wantPages, c.wantErr)
+			}
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,146 @@
+package filter
+
+import (
+	"encoding/json"
+	"github.com/astaxie/beego/context"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// 审计事件类型
+const (
+	AUDIT_LOGIN_SUCCESS     = "login_success"
+	AUDIT_LOGIN_FAILURE     = "login_failure"
+	AUDIT_LOGOUT            = "logout"
+	AUDIT_AUTHORITY_ALLOW   = "authority_allow"
+	AUDIT_AUTHORITY_DENY    = "authority_deny"
+	AUDIT_IMPERSONATE_START = "impersonate_start"
+	AUDIT_IMPERSONATE_STOP  = "impersonate_stop"
+	AUDIT_ADMIN_CHANGE      = "admin_change" // 通过管理页面修改角色、成员或资源关联，失败（含参数不合法）时Reason为原因
+	AUDIT_ADMIN_DENY        = "admin_deny"   // 管理页面拒绝的请求：缺少AdminResource或CSRF校验失败
+)
+
+// 登录及鉴权的审计事件
+type AuditEvent struct {
+	Time    time.Time `json:"time"`              // 事件时间
+	Type    string    `json:"type"`              // 事件类型
+	Client  string    `json:"client,omitempty"`  // client名称
+	UserId  string    `json:"user_id,omitempty"` // 用户Id
+	IP      string    `json:"ip,omitempty"`      // 客户端ip
+	Method  string    `json:"method,omitempty"`  // 请求方法
+	Route   string    `json:"route,omitempty"`   // 路由，鉴权事件为路由规则，其余为请求路径
+	Rule    string    `json:"rule,omitempty"`    // 命中的UrlControl/AbacRules key
+	Missing []string  `json:"missing,omitempty"` // 用户不满足的规则
+	Reason  string    `json:"reason,omitempty"`  // 失败原因
+	Target  string    `json:"target,omitempty"`  // 模拟登录的目标用户Id或被修改的对象
+	Detail  string    `json:"detail,omitempty"`  // 修改内容
+}
+
+// 审计事件输出
+type AuditSink interface {
+	Emit(event *AuditEvent)
+}
+
+// 以JSON Lines格式追加写入文件，每个事件一行
+type JSONLinesAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func NewJSONLinesAuditSink(path string) (*JSONLinesAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesAuditSink{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (s *JSONLinesAuditSink) Emit(event *AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(event); err != nil {
+		defaultLogger.Error("audit event write failed", F("error", err))
+	}
+}
+
+func (s *JSONLinesAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// 在内存中保留最近的事件，超出容量时丢弃最早的事件
+type MemoryAuditSink struct {
+	mu       sync.Mutex
+	capacity int
+	events   []AuditEvent
+}
+
+func NewMemoryAuditSink(capacity int) *MemoryAuditSink {
+	if capacity <= 0 {
+		capacity = 1000
+	}
+	return &MemoryAuditSink{capacity: capacity}
+}
+
+func (s *MemoryAuditSink) Emit(event *AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.events) >= s.capacity {
+		s.events = s.events[1:]
+	}
+	s.events = append(s.events, *event)
+}
+
+// 当前保留的事件，按时间先后排列
+func (s *MemoryAuditSink) Events() []AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]AuditEvent, len(s.events))
+	copy(events, s.events)
+	return events
+}
+
+func (s *MemoryAuditSink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = nil
+}
+
+// 对允许访问事件按比例采样，其余事件全部输出
+type SamplingAuditSink struct {
+	sink      AuditSink
+	allowRate float64
+}
+
+// allowRate为允许访问事件的输出比例，取值0~1
+func NewSamplingAuditSink(sink AuditSink, allowRate float64) *SamplingAuditSink {
+	return &SamplingAuditSink{sink: sink, allowRate: allowRate}
+}
+
+func (s *SamplingAuditSink) Emit(event *AuditEvent) {
+	if event.Type == AUDIT_AUTHORITY_ALLOW && rand.Float64() >= s.allowRate {
+		return
+	}
+	s.sink.Emit(event)
+}
+
+// 补充事件的公共字段后输出
+func (a *Auth) audit(ctx *context.Context, event *AuditEvent) {
+	if a.AuditSink == nil {
+		return
+	}
+	event.Time = time.Now()
+	event.Client = a.Name
+	if ctx != nil {
+		event.IP = ctx.Input.IP()
+		event.Method = ctx.Request.Method
+		if event.Route == "" {
+			event.Route = ctx.Input.URL()
+		}
+	}
+	a.AuditSink.Emit(event)
+}
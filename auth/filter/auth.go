@@ -6,7 +6,7 @@ import (
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/context"
 	"github.com/astaxie/beego/httplib"
-	"github.com/astaxie/beego/logs"
+	"github.com/tongwu13/golang_common/beego/i18n"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -16,6 +16,9 @@ import (
 
 const (
 	SESSION_KEY_USER = "user"
+	STATE_SEPARATOR  = "|"
+	DATA_KEY_USER    = "User"  // 登录用户写入请求上下文的key，beego控制器中可通过Data["User"]获取，模板中为.User
+	dataKeyAuth      = "_auth" // 处理本次请求的Auth，Require据此读取对应client的用户存储
 )
 
 type AuthService interface {
@@ -28,11 +31,13 @@ type AuthService interface {
 }
 
 type Auth struct {
+	Name             string // client名称，多client共用一个进程时用于隔离session和区分回调，见multiAuth.go
 	ClientId         int64
 	ClientSecret     string
 	RedirectUri      string
 	Host             string
 	AutoLoadResource bool
+	AutoLoadRole     bool // UrlControl中包含角色规则时自动开启，登录时加载用户角色
 	Scope            string
 	CacheExpire      int64
 	UrlControl       map[string][]string
@@ -40,9 +45,38 @@ type Auth struct {
 		UrlControl:
 		url - [resource1,resource2] mapping or method:url - [resource1,resource2]
 	*/
+	ResourceProvider ResourceProvider       // 本地资源来源（如PolicyPoint），为空时向sso请求用户资源
+	AbacRules        map[string][]*AbacRule // url或method:url - 属性规则，见abac.go
+	AuditSink        AuditSink              // 审计事件输出，为空时不记录
+	Metrics          Metrics                // 指标收集，为空时不记录
+	Logger           Logger                 // 日志，输出前统一脱敏
+
+	// 模拟登录，见impersonation.go
+	ApiAuth             ApiAuthService  // 用于加载被模拟用户的资源和角色
+	ImpersonateResource string          // 允许模拟登录所需的规则（资源Data、role:xxx或roletype:xxx）
+	ImpersonationBlock  map[string]bool // 模拟登录期间禁止访问的url或method:url
+
+	// 机器调用方的api key，见apiKey.go
+	ApiKeys      *ApiKeyManager
+	ApiKeyHeader string // 为空时使用X-Api-Key
+
+	UserStore UserStore // 登录用户的存储，为空时使用beego session，见userStore.go
+
+	ResourceCache *ResourceCache // 进程内共享的用户资源缓存，为空时每个session各自按CacheExpire重新加载
+
+	invalidations *invalidations  // 权限变更通知的失效时间，见webhook.go
+	refreshing    providerRefresh // 后台刷新ResourceProvider，见webhook.go
+}
+
+// 鉴权结果
+type Decision struct {
+	Allowed bool
+	Rule    string   // 命中的规则key（url或method:url），未配置规则时为空
+	Missing []string // 用户不满足的规则
 }
 
 type Config struct {
+	Name             string
 	ClientId         string
 	ClientSecret     string
 	RedirectUri      string
@@ -51,130 +85,204 @@ type Config struct {
 	Scope            string
 	CacheExpire      string
 	UrlControl       map[string]string
-}
+	ResourceProvider ResourceProvider
+	AbacRules        map[string]string
+	AuditSink        AuditSink
+	Metrics          Metrics
+	Logger           Logger
 
-func NewAuthService(config *Config) AuthService {
-	if config == nil {
-		panic("auth service init failed: config counld not be nil")
-	}
+	ApiAuth             ApiAuthService
+	ImpersonateResource string
+	ImpersonationBlock  []string
 
-	auth := &Auth{}
+	ApiKeys      *ApiKeyManager
+	ApiKeyHeader string
 
-	if clientId, err := strconv.ParseInt(config.ClientId, 10, 64); err != nil {
-		panic(fmt.Sprintf("auth service init failed: clientId is invalid %s", config.ClientId))
-	} else {
-		auth.ClientId = clientId
-	}
-	if config.AutoLoadResource == "true" {
-		auth.AutoLoadResource = true
-	}
-	if auth.AutoLoadResource {
-		if cacheExpire, err := strconv.ParseInt(config.CacheExpire, 10, 64); err != nil && cacheExpire > 0 {
-			panic(fmt.Sprintf("auth service init failed: cacheExpire is invalid %s", config.CacheExpire))
-		} else {
-			auth.CacheExpire = cacheExpire
-		}
-	}
-	auth.ClientSecret = config.ClientSecret
-	auth.RedirectUri = config.RedirectUri
-	auth.Host = config.Host
-	if config.Scope == "" {
-		auth.Scope = "all:all"
-	} else {
-		auth.Scope = config.Scope
-	}
-	auth.UrlControl = make(map[string][]string)
-	for k, v := range config.UrlControl {
-		auth.UrlControl[strings.ToLower(k)] = strings.Split(strings.ToLower(v), "|")
+	UserStore UserStore
+
+	ResourceCache *ResourceCache
+}
+
+func NewAuthService(config *Config) AuthService {
+	auth, err := newAuth(config, false)
+	if err != nil {
+		panic(fmt.Sprintf("auth service init failed: %v", err))
 	}
 	return auth
 }
 
 func (a *Auth) CheckLoginFilter(ctx *context.Context) {
+	ctx.Input.SetData(dataKeyAuth, a)
+	if a.checkApiKey(ctx) {
+		return
+	}
 	code := ctx.Input.Query("code")
 	if code == "" {
 		//没有code，判断session是否有效
-		user, ok := ctx.Input.CruSession.Get(SESSION_KEY_USER).(User)
+		user, ok := a.users().Get(ctx, a.sessionKey())
 		if !ok {
 			a.RedirectToLogin(ctx)
-		} else if a.AutoLoadResource {
-			if time.Now().Unix()-user.CacheTime > a.CacheExpire {
-				if err := user.LoadResource(a); err == nil {
-					ctx.Input.CruSession.Set(SESSION_KEY_USER, user)
-				} else {
-					a.RedirectToLogin(ctx)
+		} else if (a.AutoLoadResource || a.AutoLoadRole) && (time.Now().Unix()-user.CacheTime > a.CacheExpire || a.invalidations.stale(&user)) {
+			if err := a.reloadPermissions(&user); err == nil {
+				a.saveUser(ctx, a.sessionKey(), user)
+				a.exposeUser(ctx, user)
+			} else {
+				a.RedirectToLogin(ctx)
+			}
+		} else {
+			if a.useResourceCache(&user) {
+				// 共享缓存命中时代价很小，每次请求都取最新的资源；
+				// 未命中（如InvalidateUsers清空缓存后）时同步向sso加载，同一用户的并发请求只加载一次
+				if err := a.loadCachedResources(&user); err != nil {
+					a.log().Error("load cached resources failed", F("user_id", user.Id), F("error", err))
 				}
 			}
+			a.exposeUser(ctx, user)
 		}
 	} else {
 		//有code，本次请求来自于sso的回调
-		if token, err := a.queryTokenFromOauth2(code, ctx); err != nil {
-			logs.Error(err)
+		if err := a.loginWithCode(code, ctx); err != nil {
 			a.RedirectToLogin(ctx)
 		} else {
-			user := User{ResourceMap: make(map[string]*Resource)}
-			user.Token = token
-			if err := user.Init(a); err != nil {
-				logs.Error(err)
-			} else if a.AutoLoadResource {
-				if err := user.LoadResource(a); err != nil {
-					logs.Error(err)
-				}
-			}
-			logs.Info(user)
-			ctx.Input.CruSession.Set(SESSION_KEY_USER, user)
-			if ctx.Input.Header("x-requested-with") == "XMLHttpRequest" {
-				ctx.ResponseWriter.WriteHeader(200)
-				ctx.WriteString("登录成功")
-			} else {
-				if originUrl := ctx.Input.Query("state"); originUrl != "" {
-					ctx.Redirect(http.StatusFound, originUrl)
-				} else {
-					ctx.Redirect(http.StatusFound, "/")
-				}
-			}
+			a.loginSucceeded(ctx)
+		}
+	}
+}
+
+/**
+用code换取token并初始化用户，成功后写入session
+*/
+func (a *Auth) loginWithCode(code string, ctx *context.Context) error {
+	token, err := a.queryTokenFromOauth2(code, ctx)
+	if err != nil {
+		a.log().Error("query token failed", F("error", err))
+		a.audit(ctx, &AuditEvent{Type: AUDIT_LOGIN_FAILURE, Reason: err.Error()})
+		a.incCounter(METRIC_LOGINS, map[string]string{"result": "failure"})
+		a.incCounter(METRIC_CALLBACK_FAILURE, map[string]string{"stage": "token"})
+		return err
+	}
+	user := User{ResourceMap: make(map[string]*Resource)}
+	user.Token = token
+	if err := user.Init(a); err != nil {
+		// 无法获取用户信息时终止登录，不写入session
+		a.log().Error("init user failed", F("error", err))
+		a.audit(ctx, &AuditEvent{Type: AUDIT_LOGIN_FAILURE, UserId: user.Id, Reason: err.Error()})
+		a.incCounter(METRIC_LOGINS, map[string]string{"result": "failure"})
+		a.incCounter(METRIC_CALLBACK_FAILURE, map[string]string{"stage": "user"})
+		return err
+	}
+	if err := a.loadPermissions(&user); err != nil {
+		a.log().Error("load permissions failed", F("user_id", user.Id), F("error", err))
+	}
+	a.audit(ctx, &AuditEvent{Type: AUDIT_LOGIN_SUCCESS, UserId: user.Id})
+	a.incCounter(METRIC_LOGINS, map[string]string{"result": "success"})
+	a.log().Info("user logged in", F("user_id", user.Id), F("fullname", user.Fullname), F("resources", len(user.Resources)))
+	a.saveUser(ctx, a.sessionKey(), user)
+	return nil
+}
+
+/**
+登录成功后返回结果或跳回登录前的页面
+*/
+func (a *Auth) loginSucceeded(ctx *context.Context) {
+	if ctx.Input.Header("x-requested-with") == "XMLHttpRequest" {
+		ctx.ResponseWriter.WriteHeader(200)
+		ctx.WriteString(i18n.T(ctx, i18n.MSG_LOGIN_SUCCESS))
+	} else {
+		if originUrl := a.decodeState(ctx.Input.Query("state")); originUrl != "" {
+			ctx.Redirect(http.StatusFound, originUrl)
+		} else {
+			ctx.Redirect(http.StatusFound, "/")
+		}
+	}
+}
+
+/**
+缓存过期后重新加载用户权限
+*/
+func (a *Auth) reloadPermissions(user *User) (err error) {
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		a.incCounter(METRIC_RESOURCE_RELOADS, map[string]string{"result": result})
+	}()
+	return a.loadPermissions(user)
+}
+
+/**
+将登录用户写入请求上下文，模拟登录时同时标识管理员
+*/
+func (a *Auth) exposeUser(ctx *context.Context, user User) {
+	if user.ImpersonatedBy != "" {
+		if admin, ok := a.Impersonator(ctx); ok {
+			a.exposeImpersonation(ctx, user, admin)
+			return
 		}
 	}
+	ctx.Input.SetData(DATA_KEY_USER, user)
+}
+
+/**
+按配置加载用户资源和角色
+*/
+func (a *Auth) loadPermissions(user *User) error {
+	if user.ImpersonatedBy != "" && a.ApiAuth != nil {
+		return a.loadImpersonatedPermissions(user)
+	}
+	if a.useResourceCache(user) {
+		if err := a.loadCachedResources(user); err != nil {
+			return err
+		}
+	} else if a.AutoLoadResource {
+		if err := user.LoadResource(a); err != nil {
+			return err
+		}
+	}
+	if a.AutoLoadRole {
+		if err := user.LoadRoles(a); err != nil {
+			return err
+		}
+		user.markLoaded()
+	}
+	return nil
 }
 
 /**
 authority filter 校验对应url是否有权限
 */
 func (a *Auth) CheckAuthorityFilter(ctx *context.Context, routerPattern string) {
-	urlPattern := strings.ToLower(routerPattern)
-	method := strings.ToLower(ctx.Request.Method)
-	key := fmt.Sprintf("%s:%s", method, urlPattern)
 	user := a.CurrentUser(ctx)
-	isPass := true
-
-	// 先查看该url是否需要权限控制
-	if isPass {
-		if resources, ok := a.UrlControl[urlPattern]; ok {
-			for _, v := range resources {
-				if _, ok := user.ResourceMap[v]; !ok {
-					isPass = false
-					break
-				}
-			}
+	if a.ResourceProvider != nil && (user.Token.AccessToken != "" || user.ImpersonatedBy != "") {
+		if err := user.LoadResource(a); err != nil {
+			a.log().Error("load resources failed", F("user_id", user.Id), F("error", err))
+		} else {
+			ctx.Input.SetData(DATA_KEY_USER, user)
 		}
 	}
-
-	// 接着，查看该url的某种request method是否控制权限，key的格式为method:urlPattern
-	if isPass {
-		if resources, ok := a.UrlControl[key]; ok {
-			for _, v := range resources {
-				if _, ok := user.ResourceMap[v]; !ok {
-					isPass = false
-					break
-				}
-			}
+	var attrs RequestAttrs
+	if len(a.AbacRules) > 0 {
+		attrs = RequestAttrsFromContext(ctx)
+	}
+	decision := a.Decide(user, ctx.Request.Method, routerPattern, attrs)
+	if decision.Rule != "" {
+		result := "allow"
+		if !decision.Allowed {
+			result = "deny"
 		}
+		a.incCounter(METRIC_DECISIONS, map[string]string{"rule": decision.Rule, "result": result})
+	}
+	if !decision.Allowed {
+		a.audit(ctx, &AuditEvent{Type: AUDIT_AUTHORITY_DENY, UserId: user.Id, Route: routerPattern, Rule: decision.Rule, Missing: decision.Missing})
+	} else if decision.Rule != "" {
+		a.audit(ctx, &AuditEvent{Type: AUDIT_AUTHORITY_ALLOW, UserId: user.Id, Route: routerPattern, Rule: decision.Rule})
 	}
 
-	if !isPass {
+	if !decision.Allowed {
 		if ctx.Input.Header("x-requested-with") == "XMLHttpRequest" {
 			ctx.ResponseWriter.WriteHeader(403)
-			ctx.WriteString("已授权，访问被拒绝，当前用户没有权限访问该内容")
+			ctx.WriteString(i18n.T(ctx, i18n.MSG_PERMISSION_DENIED))
 		} else {
 			beego.Exception(403, ctx)
 		}
@@ -182,22 +290,58 @@ func (a *Auth) CheckAuthorityFilter(ctx *context.Context, routerPattern string)
 
 }
 
+/**
+计算用户对某个路由的访问权限，依次校验url规则、method:url规则以及对应的属性规则
+*/
+func (a *Auth) Decide(user User, method, routerPattern string, attrs RequestAttrs) Decision {
+	urlPattern := strings.ToLower(routerPattern)
+	key := fmt.Sprintf("%s:%s", strings.ToLower(method), urlPattern)
+	decision := Decision{Allowed: true}
+
+	if user.ImpersonatedBy != "" && a.impersonationBlocked(urlPattern, key) {
+		return Decision{Allowed: false, Rule: key, Missing: []string{"impersonation"}}
+	}
+
+	// 先查看该url是否需要权限控制，接着查看该url的某种request method是否控制权限，key的格式为method:urlPattern
+	for _, k := range []string{urlPattern, key} {
+		if rules, ok := a.UrlControl[k]; ok {
+			decision.Rule = k
+			if decision.Missing = user.missing(rules); len(decision.Missing) > 0 {
+				decision.Allowed = false
+				return decision
+			}
+		}
+	}
+
+	// 最后校验属性规则
+	for _, k := range []string{urlPattern, key} {
+		if rules, ok := a.AbacRules[k]; ok {
+			decision.Rule = k
+			if decision.Missing = abacMissing(&user, rules, attrs); len(decision.Missing) > 0 {
+				decision.Allowed = false
+				return decision
+			}
+		}
+	}
+	return decision
+}
+
 /**
 重定向到登录页或返回未登录状态
 */
 func (a *Auth) RedirectToLogin(ctx *context.Context) {
 	if ctx.Input.Header("x-requested-with") == "XMLHttpRequest" {
 		ctx.ResponseWriter.WriteHeader(401)
-		ctx.WriteString("未授权或获取授权失败，访问被拒绝")
+		ctx.WriteString(i18n.T(ctx, i18n.MSG_NOT_LOGIN))
 	} else {
 		params := url.Values{}
 		params.Add("client_id", strconv.FormatInt(a.ClientId, 10))
 		params.Add("redirect_uri", a.RedirectUri)
 		params.Add("response_type", "code")
-		params.Add("state", ctx.Input.URI())
+		params.Add("state", a.encodeState(ctx.Input.URI()))
 		params.Add("scope", a.Scope)
 		url := fmt.Sprintf("%s/oauth2/authorize?%s", a.Host, params.Encode())
-		logs.Info(url)
+		a.log().Debug("redirect to sso login", F("url", url))
 		ctx.Redirect(http.StatusFound, url)
 	}
 }
@@ -207,20 +351,10 @@ func (a *Auth) RedirectToLogin(ctx *context.Context) {
 */
 
 func (a *Auth) Login(code string, ctx *context.Context) {
-	if user, err := a.queryTokenFromOauth2(code, ctx); err != nil {
+	if err := a.loginWithCode(code, ctx); err != nil {
 		a.RedirectToLogin(ctx)
 	} else {
-		ctx.Input.CruSession.Set(SESSION_KEY_USER, user)
-		if ctx.Input.Header("x-requested-with") == "XMLHttpRequest" {
-			ctx.ResponseWriter.WriteHeader(200)
-			ctx.WriteString("登录成功")
-		} else {
-			if originUrl := ctx.Input.Query("state"); originUrl != "" {
-				ctx.Redirect(http.StatusFound, originUrl)
-			} else {
-				ctx.Redirect(http.StatusFound, "/")
-			}
-		}
+		a.loginSucceeded(ctx)
 	}
 }
 
@@ -228,37 +362,82 @@ func (a *Auth) Login(code string, ctx *context.Context) {
 登出
 */
 func (a *Auth) Logout(ctx *context.Context, state string) {
-	if user, ok := ctx.Input.CruSession.Get(SESSION_KEY_USER).(User); ok {
+	user, ok := a.users().Get(ctx, a.sessionKey())
+	if admin, impersonating := a.Impersonator(ctx); impersonating {
+		a.audit(ctx, &AuditEvent{Type: AUDIT_IMPERSONATE_STOP, UserId: admin.Id, Target: user.Id})
+		a.users().Delete(ctx, a.impersonatorKey())
+		user, ok = admin, true
+	}
+	if ok {
+		a.audit(ctx, &AuditEvent{Type: AUDIT_LOGOUT, UserId: user.Id})
 		url := "https://auth.yxapp.in/oauth2/token?access_token=" + user.Token.AccessToken
-		res, err := httplib.Delete(url).Response()
+		res, err := sendRequest(a.Metrics, a.log(), httplib.Delete(url))
 		if err != nil {
-			logs.Error(err)
+			a.log().Error("revoke token failed", F("user_id", user.Id), F("error", err))
 		} else if res.StatusCode != http.StatusOK {
-			logs.Error(res)
+			a.log().Error("revoke token failed", F("user_id", user.Id), F("status", res.StatusCode))
 		}
 	}
-	ctx.Input.CruSession.Flush()
+	if _, ok := a.users().(sessionUserStore); ok && a.Name == "" {
+		ctx.Input.CruSession.Flush()
+	} else {
+		a.users().Delete(ctx, a.sessionKey())
+	}
 	params := url.Values{}
 	params.Add("client_id", strconv.FormatInt(a.ClientId, 10))
 	params.Add("redirect_uri", a.RedirectUri)
 	params.Add("response_type", "code")
-	params.Add("state", state)
+	params.Add("state", a.encodeState(state))
 	params.Add("scope", a.Scope)
 	url := fmt.Sprintf("%s/oauth2/authorize?%s", a.Host, params.Encode())
 	ctx.Redirect(http.StatusFound, url)
 }
 
+/**
+用户在session中的key，命名的client各自使用独立的key
+*/
+func (a *Auth) sessionKey() string {
+	if a.Name == "" {
+		return SESSION_KEY_USER
+	}
+	return SESSION_KEY_USER + ":" + a.Name
+}
+
+/**
+sso回调时原样带回state，命名的client在state前加上名称，格式为name|originUrl，用于将回调路由到对应client
+*/
+func (a *Auth) encodeState(originUrl string) string {
+	if a.Name == "" {
+		return originUrl
+	}
+	return a.Name + STATE_SEPARATOR + originUrl
+}
+
+func (a *Auth) decodeState(state string) string {
+	if a.Name == "" {
+		return state
+	}
+	return strings.TrimPrefix(state, a.Name+STATE_SEPARATOR)
+}
+
 /**
 查询当前session的用户信息（未登录会返回默认用户信息）
 */
 func (a *Auth) CurrentUser(ctx *context.Context) User {
-	if user, ok := ctx.Input.CruSession.Get(SESSION_KEY_USER).(User); ok {
+	if user, ok := ctx.Input.GetData(DATA_KEY_USER).(User); ok && user.ApiKeyId != "" {
+		return user
+	}
+	if user, ok := a.users().Get(ctx, a.sessionKey()); ok {
 		return user
 	} else {
-		return User{Fullname: "未登录用户", Id: " AnonymousUser", ResourceMap: map[string]*Resource{}}
+		return anonymousUser()
 	}
 }
 
+func anonymousUser() User {
+	return User{Fullname: "未登录用户", Id: " AnonymousUser", ResourceMap: map[string]*Resource{}}
+}
+
 /**
 用code向sso请求获取access token
 */
@@ -272,7 +451,7 @@ func (a *Auth) queryTokenFromOauth2(code string, ctx *context.Context) (Token, e
 
 	url := fmt.Sprintf("%s/oauth2/token?%s", a.Host, params.Encode())
 	var token Token
-	if err := httplib.Post(url).ToJSON(&token); err != nil {
+	if err := a.requestJSON(httplib.Post(url), &token); err != nil {
 		return token, err
 	} else if token.Error != "" {
 		return token, errors.New(token.Error + ":" + token.ErrorDescription)
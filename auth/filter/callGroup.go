@@ -0,0 +1,53 @@
+package filter
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 进行中的一次调用
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// 合并相同key的并发调用，同一时刻只有一个调用真正执行，其余调用等待并共享结果
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// 执行fn，shared表示结果是否来自其他调用者发起的同一调用
+func (g *callGroup) do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+	}()
+	func() {
+		// fn panic时作为错误返回给全部调用者，避免等待者一直阻塞
+		defer c.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				c.val, c.err = nil, fmt.Errorf("call %s panic: %v", key, r)
+			}
+		}()
+		c.val, c.err = fn()
+	}()
+	return c.val, c.err, false
+}
@@ -0,0 +1,489 @@
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// 变更步骤类型
+const (
+	STEP_ADD_ROLE         = "add_role"
+	STEP_ADD_RELATIONS    = "add_relations"
+	STEP_UPDATE_RELATIONS = "update_relations"
+	STEP_DELETE_RELATIONS = "delete_relations"
+	STEP_ADD_USERS        = "add_users"
+	STEP_DELETE_USERS     = "delete_users"
+)
+
+// 变更集状态
+const (
+	CHANGESET_PENDING     = "pending"
+	CHANGESET_RUNNING     = "running"
+	CHANGESET_APPLIED     = "applied"
+	CHANGESET_ROLLED_BACK = "rolled_back"
+	CHANGESET_FAILED      = "failed" // 回滚也失败，需要人工处理
+)
+
+var (
+	ErrChangeSetFinished = errors.New("change set is already finished")
+	ErrInvalidCount      = errors.New("api returned an invalid count")
+)
+
+// 变更步骤，RoleId为负数时引用本变更集中第-RoleId个步骤（AddRole）创建的角色
+type ChangeStep struct {
+	Op          string     `json:"op"`
+	RoleId      int        `json:"role_id"`
+	Name        string     `json:"name,omitempty"`
+	Description string     `json:"description,omitempty"`
+	ParentId    int        `json:"parent_id,omitempty"`
+	ResIds      []int      `json:"res_ids,omitempty"`
+	Users       []UserInfo `json:"users,omitempty"`
+
+	// 执行状态
+	Prepared    bool       `json:"prepared"` // 已记录执行前的状态
+	Done        bool       `json:"done"`
+	Compensated bool       `json:"compensated"`
+	ResultId    int        `json:"result_id,omitempty"`     // AddRole创建的角色id
+	PrevResIds  []int      `json:"prev_res_ids,omitempty"`  // 执行前角色关联的资源
+	PrevUsers   []UserInfo `json:"prev_users,omitempty"`    // 执行前角色中的用户
+	PrevRoleIds []int      `json:"prev_role_ids,omitempty"` // AddRole执行前已存在的同名同父角色
+}
+
+// 多步RBAC变更，失败时按相反顺序执行补偿调用，恢复到变更前的状态
+//
+// 设置journal路径后每一步执行前后都会写入该文件，进程中断后可通过LoadChangeSet读取并继续执行或回滚；
+// AddRole中断或结果未知时，按名称和父角色查找执行前不存在的角色，避免重复创建或回滚时遗漏：
+//
+//	cs := filter.NewChangeSet(api, "/var/lib/app/changeset.json")
+//	role := cs.AddRole("ops", "运维", 0)
+//	cs.AddRelations(role, []int{1, 2})
+//	cs.AddUsers(role, []filter.UserInfo{{UserId: "tom"}})
+//	report, err := cs.Apply()
+type ChangeSet struct {
+	Id     string        `json:"id"`
+	Status string        `json:"status"`
+	Steps  []*ChangeStep `json:"steps"`
+
+	api     ApiAuthService
+	journal string
+}
+
+// 执行失败的步骤及回滚结果
+type ChangeSetError struct {
+	Step        int
+	Err         error
+	RollbackErr error
+}
+
+func (e *ChangeSetError) Error() string {
+	msg := fmt.Sprintf("change set step %d failed: %v", e.Step, e.Err)
+	if e.RollbackErr != nil {
+		msg += fmt.Sprintf("; rollback failed: %v", e.RollbackErr)
+	}
+	return msg
+}
+
+// journal为空时不持久化
+func NewChangeSet(api ApiAuthService, journal string) *ChangeSet {
+	id, _ := randomHex(8)
+	return &ChangeSet{Id: id, Status: CHANGESET_PENDING, api: api, journal: journal}
+}
+
+// 读取中断的变更集，之后可调用Resume继续执行或Rollback回滚
+func LoadChangeSet(api ApiAuthService, journal string) (*ChangeSet, error) {
+	data, err := ioutil.ReadFile(journal)
+	if err != nil {
+		return nil, err
+	}
+	cs := &ChangeSet{}
+	if err := json.Unmarshal(data, cs); err != nil {
+		return nil, err
+	}
+	cs.api, cs.journal = api, journal
+	return cs, nil
+}
+
+func (cs *ChangeSet) add(step *ChangeStep) int {
+	cs.Steps = append(cs.Steps, step)
+	return -len(cs.Steps)
+}
+
+// 创建角色，返回的引用可作为后续步骤的roleId
+func (cs *ChangeSet) AddRole(name, description string, parentId int) int {
+	return cs.add(&ChangeStep{Op: STEP_ADD_ROLE, Name: name, Description: description, ParentId: parentId})
+}
+
+func (cs *ChangeSet) AddRelations(roleId int, resIds []int) {
+	cs.add(&ChangeStep{Op: STEP_ADD_RELATIONS, RoleId: roleId, ResIds: resIds})
+}
+
+func (cs *ChangeSet) UpdateRelations(roleId int, resIds []int) {
+	cs.add(&ChangeStep{Op: STEP_UPDATE_RELATIONS, RoleId: roleId, ResIds: resIds})
+}
+
+func (cs *ChangeSet) DeleteRelations(roleId int, resIds []int) {
+	cs.add(&ChangeStep{Op: STEP_DELETE_RELATIONS, RoleId: roleId, ResIds: resIds})
+}
+
+func (cs *ChangeSet) AddUsers(roleId int, users []UserInfo) {
+	cs.add(&ChangeStep{Op: STEP_ADD_USERS, RoleId: roleId, Users: users})
+}
+
+func (cs *ChangeSet) DeleteUsers(roleId int, userIds []string) {
+	users := make([]UserInfo, 0, len(userIds))
+	for _, id := range userIds {
+		users = append(users, UserInfo{UserId: id})
+	}
+	cs.add(&ChangeStep{Op: STEP_DELETE_USERS, RoleId: roleId, Users: users})
+}
+
+// 执行全部步骤，失败时自动回滚
+func (cs *ChangeSet) Apply() (*OpReport, error) {
+	if cs.Status != CHANGESET_PENDING {
+		return nil, ErrChangeSetFinished
+	}
+	return cs.Resume()
+}
+
+// 从第一个未完成的步骤继续执行，失败时自动回滚
+func (cs *ChangeSet) Resume() (*OpReport, error) {
+	report := &OpReport{Op: "apply change set " + cs.Id}
+	if cs.Status != CHANGESET_PENDING && cs.Status != CHANGESET_RUNNING {
+		return report, ErrChangeSetFinished
+	}
+	cs.Status = CHANGESET_RUNNING
+	if err := cs.save(); err != nil {
+		return report, err
+	}
+	for i, step := range cs.Steps {
+		if step.Done {
+			continue
+		}
+		if err := cs.execute(step, report); err != nil {
+			csErr := &ChangeSetError{Step: i, Err: err}
+			csErr.RollbackErr = cs.rollback(report)
+			return report, csErr
+		}
+		if err := cs.save(); err != nil {
+			return report, err
+		}
+	}
+	cs.Status = CHANGESET_APPLIED
+	return report, cs.save()
+}
+
+// 按相反顺序补偿已完成的步骤
+func (cs *ChangeSet) Rollback() (*OpReport, error) {
+	report := &OpReport{Op: "rollback change set " + cs.Id}
+	if cs.Status == CHANGESET_ROLLED_BACK {
+		return report, ErrChangeSetFinished
+	}
+	return report, cs.rollback(report)
+}
+
+func (cs *ChangeSet) rollback(report *OpReport) error {
+	for i := len(cs.Steps) - 1; i >= 0; i-- {
+		step := cs.Steps[i]
+		if step.Op == STEP_ADD_ROLE && step.Prepared && !step.Done {
+			// 调用AddRole后中断或返回错误时角色可能已创建
+			if parentId, err := cs.roleId(step.ParentId); err == nil {
+				if _, err := cs.reconcileRole(step, parentId, report); err != nil {
+					cs.Status = CHANGESET_FAILED
+					cs.save()
+					return err
+				}
+			}
+		}
+		// 已记录原状态的步骤调用失败时也可能已生效，补偿基于原状态，未生效时补偿不产生变化
+		if step.Compensated || !step.Done && (step.Op == STEP_ADD_ROLE || !step.Prepared) {
+			continue
+		}
+		if err := cs.compensate(step, report); err != nil {
+			cs.Status = CHANGESET_FAILED
+			cs.save()
+			return err
+		}
+		step.Compensated = true
+		if err := cs.save(); err != nil {
+			return err
+		}
+	}
+	cs.Status = CHANGESET_ROLLED_BACK
+	return cs.save()
+}
+
+// 解析角色引用
+func (cs *ChangeSet) roleId(ref int) (int, error) {
+	if ref >= 0 {
+		return ref, nil
+	}
+	idx := -ref - 1
+	if idx >= len(cs.Steps) || cs.Steps[idx].Op != STEP_ADD_ROLE || !cs.Steps[idx].Done {
+		return 0, fmt.Errorf("invalid role reference %d", ref)
+	}
+	return cs.Steps[idx].ResultId, nil
+}
+
+func (cs *ChangeSet) execute(step *ChangeStep, report *OpReport) error {
+	if step.Op == STEP_ADD_ROLE {
+		return cs.addRole(step, report)
+	}
+
+	roleId, err := cs.roleId(step.RoleId)
+	if err != nil {
+		return err
+	}
+	// 执行前记录原状态并写入journal，用于补偿；中断后继续执行时沿用已记录的状态
+	switch step.Op {
+	case STEP_ADD_RELATIONS, STEP_UPDATE_RELATIONS, STEP_DELETE_RELATIONS:
+		if !step.Prepared {
+			if step.PrevResIds, err = cs.relations(roleId, report); err != nil {
+				return err
+			}
+		}
+	case STEP_ADD_USERS, STEP_DELETE_USERS:
+		if !step.Prepared {
+			if step.PrevUsers, err = cs.users(roleId, report); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown change step %s", step.Op)
+	}
+	if !step.Prepared {
+		step.Prepared = true
+		if err := cs.save(); err != nil {
+			return err
+		}
+	}
+
+	var n int
+	switch step.Op {
+	case STEP_ADD_RELATIONS:
+		n, err = checkCount(cs.api.AddRelations(roleId, step.ResIds))
+		report.record("AddRelations", n, err, roleId, step.ResIds)
+	case STEP_UPDATE_RELATIONS:
+		n, err = checkCount(cs.api.UpdateRelations(roleId, step.ResIds))
+		report.record("UpdateRelations", n, err, roleId, step.ResIds)
+	case STEP_DELETE_RELATIONS:
+		n, err = checkCount(cs.api.DeleteRelations(roleId, step.ResIds))
+		report.record("DeleteRelations", n, err, roleId, step.ResIds)
+	case STEP_ADD_USERS:
+		n, err = checkCount(cs.api.AddUserToRole(roleId, step.Users))
+		report.record("AddUserToRole", n, err, roleId, step.Users)
+	case STEP_DELETE_USERS:
+		ids := userIds(step.Users)
+		n, err = checkCount(cs.api.DeleteUserFromRole(roleId, ids))
+		report.record("DeleteUserFromRole", n, err, roleId, ids)
+	}
+	if err != nil {
+		return err
+	}
+	step.Done = true
+	return nil
+}
+
+// 创建角色前记录已存在的同名同父角色并写入journal；中断后继续执行时先查找上次已创建的角色
+func (cs *ChangeSet) addRole(step *ChangeStep, report *OpReport) error {
+	parentId, err := cs.roleId(step.ParentId)
+	if err != nil {
+		return err
+	}
+	if step.Prepared {
+		if found, err := cs.reconcileRole(step, parentId, report); err != nil || found {
+			return err
+		}
+	} else {
+		if step.PrevRoleIds, err = cs.rolesNamed(step.Name, parentId, report); err != nil {
+			return err
+		}
+		step.Prepared = true
+		if err := cs.save(); err != nil {
+			return err
+		}
+	}
+	id, err := cs.api.AddRole(step.Name, step.Description, parentId)
+	report.record("AddRole", id, err, step.Name, step.Description, parentId)
+	if err != nil {
+		return err
+	}
+	step.ResultId, step.Done = id, true
+	return nil
+}
+
+// 查找执行前不存在的同名同父角色，找到时视为本步骤创建并标记为已完成
+func (cs *ChangeSet) reconcileRole(step *ChangeStep, parentId int, report *OpReport) (bool, error) {
+	ids, err := cs.rolesNamed(step.Name, parentId, report)
+	if err != nil {
+		return false, err
+	}
+	prev := make(map[int]bool, len(step.PrevRoleIds))
+	for _, id := range step.PrevRoleIds {
+		prev[id] = true
+	}
+	var created []int
+	for _, id := range ids {
+		if !prev[id] {
+			created = append(created, id)
+		}
+	}
+	switch len(created) {
+	case 0:
+		return false, nil
+	case 1:
+		step.ResultId, step.Done = created[0], true
+		return true, cs.save()
+	}
+	return false, fmt.Errorf("found %d new roles named %s under parent %d", len(created), step.Name, parentId)
+}
+
+// 父角色为parentId的同名角色id
+func (cs *ChangeSet) rolesNamed(name string, parentId int, report *OpReport) ([]int, error) {
+	roles, err := cs.api.GetAllRole(false, false)
+	report.record("GetAllRole", len(roles), err, false, false)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, r := range roles {
+		if r.Name == name && r.ParentId == parentId {
+			ids = append(ids, r.Id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (cs *ChangeSet) compensate(step *ChangeStep, report *OpReport) error {
+	if step.Op == STEP_ADD_ROLE {
+		_, err := cs.api.DeleteRole(step.ResultId)
+		report.record("DeleteRole", nil, err, step.ResultId)
+		return err
+	}
+	roleId, err := cs.roleId(step.RoleId)
+	if err != nil {
+		return err
+	}
+	switch step.Op {
+	case STEP_ADD_RELATIONS:
+		// 只删除本步骤新增的关联
+		prev := make(map[int]bool, len(step.PrevResIds))
+		for _, id := range step.PrevResIds {
+			prev[id] = true
+		}
+		var added []int
+		for _, id := range step.ResIds {
+			if !prev[id] {
+				added = append(added, id)
+			}
+		}
+		if len(added) == 0 {
+			return nil
+		}
+		n, err := checkCount(cs.api.DeleteRelations(roleId, added))
+		report.record("DeleteRelations", n, err, roleId, added)
+		return err
+	case STEP_UPDATE_RELATIONS, STEP_DELETE_RELATIONS:
+		n, err := checkCount(cs.api.UpdateRelations(roleId, step.PrevResIds))
+		report.record("UpdateRelations", n, err, roleId, step.PrevResIds)
+		return err
+	case STEP_ADD_USERS:
+		prev := make(map[string]bool, len(step.PrevUsers))
+		for _, u := range step.PrevUsers {
+			prev[u.UserId] = true
+		}
+		var added []string
+		for _, u := range step.Users {
+			if !prev[u.UserId] {
+				added = append(added, u.UserId)
+			}
+		}
+		if len(added) == 0 {
+			return nil
+		}
+		n, err := checkCount(cs.api.DeleteUserFromRole(roleId, added))
+		report.record("DeleteUserFromRole", n, err, roleId, added)
+		return err
+	case STEP_DELETE_USERS:
+		deleted := make(map[string]bool, len(step.Users))
+		for _, u := range step.Users {
+			deleted[u.UserId] = true
+		}
+		var restore []UserInfo
+		for _, u := range step.PrevUsers {
+			if deleted[u.UserId] {
+				restore = append(restore, u)
+			}
+		}
+		if len(restore) == 0 {
+			return nil
+		}
+		n, err := checkCount(cs.api.AddUserToRole(roleId, restore))
+		report.record("AddUserToRole", n, err, roleId, restore)
+		return err
+	}
+	return fmt.Errorf("unknown change step %s", step.Op)
+}
+
+func (cs *ChangeSet) relations(roleId int, report *OpReport) ([]int, error) {
+	infos, err := cs.api.GetRelatedInfo(roleId)
+	report.record("GetRelatedInfo", len(infos), err, roleId)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(infos))
+	for _, info := range infos {
+		ids = append(ids, info.ResourceId)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (cs *ChangeSet) users(roleId int, report *OpReport) ([]UserInfo, error) {
+	roleUsers, err := cs.api.GetUsersOfRole(roleId)
+	report.record("GetUsersOfRole", len(roleUsers), err, roleId)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]UserInfo, 0, len(roleUsers))
+	for _, u := range roleUsers {
+		users = append(users, UserInfo{UserId: u.UserId, RoleType: u.RoleType})
+	}
+	return users, nil
+}
+
+// 接口失败时可能返回负数而没有错误，视为失败
+func checkCount(n int, err error) (int, error) {
+	if err == nil && n < 0 {
+		err = ErrInvalidCount
+	}
+	return n, err
+}
+
+func userIds(users []UserInfo) []string {
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.UserId)
+	}
+	return ids
+}
+
+// 写入journal，先写临时文件再重命名，避免中断时留下不完整的文件
+func (cs *ChangeSet) save() error {
+	if cs.journal == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(cs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := cs.journal + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cs.journal)
+}
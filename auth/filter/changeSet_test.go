@@ -0,0 +1,296 @@
+package filter
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var errFake = errors.New("fake api error")
+
+// 内存中的ApiAuthService，只实现ChangeSet用到的方法
+type fakeRbacApi struct {
+	ApiAuthService
+	nextId    int
+	roles     map[int]*Role
+	relations map[int]map[int]bool
+	users     map[int]map[string]UserInfo
+	fail      string // 该方法第一次调用时返回errFake
+	failAfter bool   // 先执行再返回错误，模拟结果未知的调用
+	negative  bool   // 失败时返回-1而没有错误，与ApiAuth的部分接口一致
+}
+
+func newFakeRbacApi() *fakeRbacApi {
+	return &fakeRbacApi{
+		nextId:    100,
+		roles:     make(map[int]*Role),
+		relations: make(map[int]map[int]bool),
+		users:     make(map[int]map[string]UserInfo),
+	}
+}
+
+func (f *fakeRbacApi) failed(method string, apply func()) error {
+	if f.fail != method {
+		apply()
+		return nil
+	}
+	f.fail = ""
+	if f.failAfter {
+		apply()
+	}
+	return errFake
+}
+
+func (f *fakeRbacApi) count(method string, apply func()) (int, error) {
+	if err := f.failed(method, apply); err != nil {
+		if f.negative {
+			return -1, nil
+		}
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (f *fakeRbacApi) GetAllRole(relatedResource, relatedUser bool) ([]*Role, error) {
+	roles := make([]*Role, 0, len(f.roles))
+	for _, r := range f.roles {
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+
+func (f *fakeRbacApi) AddRole(name, description string, parentId int) (int, error) {
+	var id int
+	err := f.failed("AddRole", func() {
+		f.nextId++
+		id = f.nextId
+		f.roles[id] = &Role{Id: id, Name: name, Description: description, ParentId: parentId}
+	})
+	return id, err
+}
+
+func (f *fakeRbacApi) DeleteRole(roleId int) (*DeleteRoleInfo, error) {
+	return &DeleteRoleInfo{}, f.failed("DeleteRole", func() {
+		delete(f.roles, roleId)
+		delete(f.relations, roleId)
+		delete(f.users, roleId)
+	})
+}
+
+func (f *fakeRbacApi) GetRelatedInfo(roleId int) ([]*RelatedInfo, error) {
+	var infos []*RelatedInfo
+	for id := range f.relations[roleId] {
+		infos = append(infos, &RelatedInfo{RoleId: roleId, ResourceId: id})
+	}
+	return infos, nil
+}
+
+func (f *fakeRbacApi) setRelations(method string, roleId int, fn func(m map[int]bool)) (int, error) {
+	return f.count(method, func() {
+		if f.relations[roleId] == nil {
+			f.relations[roleId] = make(map[int]bool)
+		}
+		fn(f.relations[roleId])
+	})
+}
+
+func (f *fakeRbacApi) AddRelations(roleId int, resIds []int) (int, error) {
+	return f.setRelations("AddRelations", roleId, func(m map[int]bool) {
+		for _, id := range resIds {
+			m[id] = true
+		}
+	})
+}
+
+func (f *fakeRbacApi) UpdateRelations(roleId int, resIds []int) (int, error) {
+	return f.setRelations("UpdateRelations", roleId, func(m map[int]bool) {
+		for id := range m {
+			delete(m, id)
+		}
+		for _, id := range resIds {
+			m[id] = true
+		}
+	})
+}
+
+func (f *fakeRbacApi) DeleteRelations(roleId int, resIds []int) (int, error) {
+	return f.setRelations("DeleteRelations", roleId, func(m map[int]bool) {
+		for _, id := range resIds {
+			delete(m, id)
+		}
+	})
+}
+
+func (f *fakeRbacApi) GetUsersOfRole(roleId int) ([]*RoleUser, error) {
+	var users []*RoleUser
+	for _, u := range f.users[roleId] {
+		users = append(users, &RoleUser{RoleId: roleId, UserId: u.UserId, RoleType: u.RoleType})
+	}
+	return users, nil
+}
+
+func (f *fakeRbacApi) AddUserToRole(roleId int, infos []UserInfo) (int, error) {
+	return f.count("AddUserToRole", func() {
+		if f.users[roleId] == nil {
+			f.users[roleId] = make(map[string]UserInfo)
+		}
+		for _, u := range infos {
+			f.users[roleId][u.UserId] = u
+		}
+	})
+}
+
+func (f *fakeRbacApi) DeleteUserFromRole(roleId int, names []string) (int, error) {
+	return f.count("DeleteUserFromRole", func() {
+		for _, name := range names {
+			delete(f.users[roleId], name)
+		}
+	})
+}
+
+// 角色名 - 排序后的资源id和用户id，用于比较变更前后的状态
+func (f *fakeRbacApi) state() map[string][]interface{} {
+	state := make(map[string][]interface{})
+	for id, r := range f.roles {
+		var resIds []int
+		for resId, ok := range f.relations[id] {
+			if ok {
+				resIds = append(resIds, resId)
+			}
+		}
+		sort.Ints(resIds)
+		var userIds []string
+		for userId := range f.users[id] {
+			userIds = append(userIds, userId)
+		}
+		sort.Strings(userIds)
+		state[r.Name] = []interface{}{r.ParentId, resIds, userIds}
+	}
+	return state
+}
+
+func TestChangeSetCompensation(t *testing.T) {
+	cases := []struct {
+		name       string
+		fail       string
+		failAfter  bool
+		negative   bool
+		wantStatus string
+		wantState  map[string][]interface{} // 为nil时期望与变更前相同
+	}{
+		{"applied", "", false, false, CHANGESET_APPLIED, map[string][]interface{}{
+			"root": {0, []int{2, 3}, []string{"bob"}},
+			"ops":  {1, []int{1, 2}, []string{"tom"}},
+		}},
+		{"add role fails", "AddRole", false, false, CHANGESET_ROLLED_BACK, nil},
+		{"add role fails after creating", "AddRole", true, false, CHANGESET_ROLLED_BACK, nil},
+		{"add relations fails", "AddRelations", false, false, CHANGESET_ROLLED_BACK, nil},
+		{"add users fails", "AddUserToRole", false, false, CHANGESET_ROLLED_BACK, nil},
+		{"update relations fails", "UpdateRelations", false, false, CHANGESET_ROLLED_BACK, nil},
+		{"delete users fails after deleting", "DeleteUserFromRole", true, false, CHANGESET_ROLLED_BACK, nil},
+		{"delete users returns negative count", "DeleteUserFromRole", false, true, CHANGESET_ROLLED_BACK, nil},
+		{"add relations returns negative count", "AddRelations", false, true, CHANGESET_ROLLED_BACK, nil},
+	}
+	for _, c := range cases {
+		api := newFakeRbacApi()
+		api.roles[1] = &Role{Id: 1, Name: "root"}
+		api.relations[1] = map[int]bool{1: true, 2: true}
+		api.users[1] = map[string]UserInfo{"alice": {UserId: "alice"}, "bob": {UserId: "bob"}}
+		before := api.state()
+
+		cs := NewChangeSet(api, "")
+		role := cs.AddRole("ops", "运维", 1)
+		cs.AddRelations(role, []int{1, 2})
+		cs.AddUsers(role, []UserInfo{{UserId: "tom"}})
+		cs.UpdateRelations(1, []int{2, 3})
+		cs.DeleteUsers(1, []string{"alice"})
+		api.fail, api.failAfter, api.negative = c.fail, c.failAfter, c.negative
+
+		_, err := cs.Apply()
+		if c.wantStatus == CHANGESET_APPLIED && err != nil {
+			t.Errorf("%s: Apply error %v", c.name, err)
+		}
+		if c.wantStatus != CHANGESET_APPLIED {
+			if csErr, ok := err.(*ChangeSetError); !ok || csErr.RollbackErr != nil {
+				t.Errorf("%s: Apply error %v, want ChangeSetError without rollback error", c.name, err)
+			}
+		}
+		if cs.Status != c.wantStatus {
+			t.Errorf("%s: status %s, want %s", c.name, cs.Status, c.wantStatus)
+		}
+		want := c.wantState
+		if want == nil {
+			want = before
+		}
+		if got := api.state(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: state %v, want %v", c.name, got, want)
+		}
+	}
+}
+
+func TestChangeSetResumeAddRole(t *testing.T) {
+	cases := []struct {
+		name      string
+		created   bool // 中断前AddRole已成功
+		existing  bool // 变更前已有同名同父角色
+		wantRoles int
+	}{
+		{"interrupted before call", false, false, 1},
+		{"interrupted after call", true, false, 1},
+		{"existing role kept", false, true, 2},
+		{"existing role and created", true, true, 2},
+	}
+	for _, c := range cases {
+		api := newFakeRbacApi()
+		if c.existing {
+			api.roles[1] = &Role{Id: 1, Name: "ops"}
+		}
+		dir, err := ioutil.TempDir("", "changeset")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		journal := filepath.Join(dir, "changeset.json")
+		cs := NewChangeSet(api, journal)
+		cs.AddRole("ops", "", 0)
+		// 模拟执行AddRole时进程中断：记录意图后返回
+		step := cs.Steps[0]
+		cs.Status = CHANGESET_RUNNING
+		prev, err := cs.rolesNamed(step.Name, 0, &OpReport{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		step.PrevRoleIds, step.Prepared = prev, true
+		if err := cs.save(); err != nil {
+			t.Fatal(err)
+		}
+		if c.created {
+			api.AddRole("ops", "", 0)
+		}
+
+		loaded, err := LoadChangeSet(api, journal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loaded.Resume(); err != nil {
+			t.Errorf("%s: Resume error %v", c.name, err)
+			continue
+		}
+		if len(api.roles) != c.wantRoles {
+			t.Errorf("%s: %d roles, want %d", c.name, len(api.roles), c.wantRoles)
+		}
+		if id := loaded.Steps[0].ResultId; id == 1 || api.roles[id] == nil {
+			t.Errorf("%s: result id %d does not refer to the created role", c.name, id)
+		}
+		if _, err := loaded.Rollback(); err != nil {
+			t.Errorf("%s: Rollback error %v", c.name, err)
+		}
+		if c.existing && api.roles[1] == nil {
+			t.Errorf("%s: existing role deleted by rollback", c.name)
+		}
+	}
+}
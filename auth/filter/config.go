@@ -0,0 +1,418 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/astaxie/beego/config"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 未配置CacheExpire时用户权限的缓存时间（秒）
+const DEFAULT_CACHE_EXPIRE = 300
+
+// 配置校验错误，包含全部不合法的配置项
+type ConfigError struct {
+	Errs []error
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+func (e *ConfigError) add(format string, args ...interface{}) {
+	e.Errs = append(e.Errs, fmt.Errorf(format, args...))
+}
+
+// 没有错误时返回nil
+func (e *ConfigError) err() error {
+	if len(e.Errs) == 0 {
+		return nil
+	}
+	return e
+}
+
+// 校验url必须为带host的http(s)地址
+func validateHttpUrl(errs *ConfigError, name, value string) {
+	if value == "" {
+		errs.add("%s is empty", name)
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		errs.add("%s is invalid %s: %v", name, value, err)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.add("%s is invalid %s: expect http(s)://host", name, value)
+	}
+}
+
+var urlControlMethods = map[string]bool{
+	"get": true, "post": true, "put": true, "delete": true, "patch": true, "head": true, "options": true,
+}
+
+// 校验UrlControl/AbacRules的key：url或method:url，url以/开头
+func validateRuleKey(errs *ConfigError, name, key string) {
+	path := key
+	if idx := strings.Index(key, ":/"); idx > 0 {
+		if method := strings.ToLower(key[:idx]); !urlControlMethods[method] {
+			errs.add("%s key %q: unknown method %s", name, key, method)
+		}
+		path = key[idx+1:]
+	}
+	if !strings.HasPrefix(path, "/") {
+		errs.add("%s key %q: expect url or method:url", name, key)
+	}
+}
+
+// 校验UrlControl的规则：资源Data、role:name或roletype:type[@name]，以|分隔
+func validateUrlControlRules(errs *ConfigError, key, value string) {
+	for _, rule := range strings.Split(value, "|") {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		switch {
+		case rule == "":
+			errs.add("UrlControl %q: empty rule", key)
+		case rule == RULE_PREFIX_ROLE:
+			errs.add("UrlControl %q: role name is empty", key)
+		case strings.HasPrefix(rule, RULE_PREFIX_ROLE_TYPE):
+			roleType := strings.TrimPrefix(rule, RULE_PREFIX_ROLE_TYPE)
+			if roleType == "" || strings.HasPrefix(roleType, "@") || strings.HasSuffix(roleType, "@") {
+				errs.add("UrlControl %q: invalid role type rule %s", key, rule)
+			}
+		}
+	}
+}
+
+// 校验配置并创建AuthService，配置不合法时返回*ConfigError
+func NewAuthServiceE(config *Config) (AuthService, error) {
+	auth, err := newAuth(config, true)
+	if err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// strict为false时只拒绝旧版NewAuthService同样拒绝的配置（及新增功能的错误配置），
+// 其余校验项忽略，CacheExpire未配置时为0，与旧版行为一致
+func newAuth(config *Config, strict bool) (*Auth, error) {
+	if config == nil {
+		return nil, &ConfigError{Errs: []error{fmt.Errorf("config counld not be nil")}}
+	}
+	errs := &ConfigError{}
+	lint := &ConfigError{} // 只有strict时报告
+	auth := &Auth{
+		Name:             config.Name,
+		ClientSecret:     config.ClientSecret,
+		RedirectUri:      config.RedirectUri,
+		Host:             strings.TrimSuffix(config.Host, "/"),
+		Scope:            config.Scope,
+		ResourceProvider: config.ResourceProvider,
+		AuditSink:        config.AuditSink,
+		Metrics:          config.Metrics,
+		Logger:           NewRedactingLogger(config.Logger),
+		UrlControl:       make(map[string][]string),
+		AbacRules:        make(map[string][]*AbacRule),
+
+		ApiAuth:             config.ApiAuth,
+		ImpersonateResource: strings.ToLower(config.ImpersonateResource),
+		ImpersonationBlock:  parseImpersonationBlock(config.ImpersonationBlock),
+		ApiKeys:             config.ApiKeys,
+		ApiKeyHeader:        config.ApiKeyHeader,
+		UserStore:           config.UserStore,
+		ResourceCache:       config.ResourceCache,
+		invalidations:       newInvalidations(),
+	}
+
+	if clientId, err := strconv.ParseInt(config.ClientId, 10, 64); err != nil {
+		errs.add("clientId is invalid %s", config.ClientId)
+	} else {
+		auth.ClientId = clientId
+	}
+	if config.ClientSecret == "" {
+		lint.add("clientSecret is empty")
+	}
+	validateHttpUrl(lint, "host", config.Host)
+	validateHttpUrl(lint, "redirectUri", config.RedirectUri)
+	if auth.Scope == "" {
+		auth.Scope = "all:all"
+	}
+
+	switch config.AutoLoadResource {
+	case "true":
+		auth.AutoLoadResource = true
+	case "", "false":
+	default:
+		lint.add("autoLoadResource is invalid %s: expect true or false", config.AutoLoadResource)
+	}
+	if config.ResourceProvider != nil {
+		auth.AutoLoadResource = true
+	}
+
+	for k, v := range config.UrlControl {
+		validateRuleKey(lint, "UrlControl", k)
+		validateUrlControlRules(lint, k, v)
+		rules := strings.Split(strings.ToLower(v), "|")
+		for i, rule := range rules {
+			rules[i] = strings.TrimSpace(rule)
+			if isRoleRule(rules[i]) {
+				auth.AutoLoadRole = true
+			}
+		}
+		auth.UrlControl[strings.ToLower(k)] = rules
+	}
+	for k, v := range config.AbacRules {
+		validateRuleKey(errs, "AbacRules", k)
+		rules, err := ParseAbacRules(v)
+		if err != nil {
+			errs.add("AbacRules %q: %v", k, err)
+			continue
+		}
+		auth.AbacRules[strings.ToLower(k)] = rules
+		auth.AutoLoadResource = true
+	}
+
+	if config.ImpersonateResource != "" && config.ApiAuth == nil {
+		errs.add("impersonateResource requires apiAuth")
+	}
+	// 校验管理员的角色规则时需要已加载的角色
+	if isRoleRule(auth.ImpersonateResource) {
+		auth.AutoLoadRole = true
+	}
+	for _, r := range config.ImpersonationBlock {
+		validateRuleKey(errs, "ImpersonationBlock", r)
+	}
+
+	if strict {
+		auth.CacheExpire = DEFAULT_CACHE_EXPIRE
+	}
+	if config.CacheExpire != "" {
+		if cacheExpire, err := strconv.ParseInt(config.CacheExpire, 10, 64); err != nil || cacheExpire < 0 {
+			lint.add("cacheExpire is invalid %s", config.CacheExpire)
+		} else {
+			auth.CacheExpire = cacheExpire
+		}
+	}
+
+	if strict {
+		errs.Errs = append(errs.Errs, lint.Errs...)
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// 校验配置并创建ApiAuthService，配置不合法时返回*ConfigError
+func NewApiAuthE(config *ApiConfig) (ApiAuthService, error) {
+	apiAuth, err := newApiAuth(config, true)
+	if err != nil {
+		return nil, err
+	}
+	return apiAuth, nil
+}
+
+// strict为false时只校验clientId，与旧版NewApiAuth一致
+func newApiAuth(config *ApiConfig, strict bool) (*ApiAuth, error) {
+	if config == nil {
+		return nil, &ConfigError{Errs: []error{fmt.Errorf("config counld not be nil")}}
+	}
+	errs := &ConfigError{}
+	lint := &ConfigError{}
+	apiAuth := &ApiAuth{
+		ClientSecret: config.ClientSecret,
+		RedirectUri:  config.RedirectUri,
+		ApiHost:      strings.TrimSuffix(config.ApiHost, "/"),
+		Metrics:      config.Metrics,
+		Logger:       NewRedactingLogger(config.Logger),
+	}
+	if clientId, err := strconv.ParseInt(config.ClientId, 10, 64); err != nil {
+		errs.add("clientId is invalid %s", config.ClientId)
+	} else {
+		apiAuth.ClientId = clientId
+	}
+	if config.ClientSecret == "" {
+		lint.add("clientSecret is empty")
+	}
+	validateHttpUrl(lint, "apiHost", config.ApiHost)
+	if config.RedirectUri != "" {
+		validateHttpUrl(lint, "redirectUri", config.RedirectUri)
+	}
+
+	if strict {
+		errs.Errs = append(errs.Errs, lint.Errs...)
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return apiAuth, nil
+}
+
+// 解析 key=value;key=value 格式的规则列表，key中的第一个=之前为规则key
+func parseRuleList(value string) map[string]string {
+	rules := make(map[string]string)
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) == 2 {
+			rules[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		} else {
+			// 没有=时保留原样，由校验报告语法错误
+			rules[kv[0]] = ""
+		}
+	}
+	return rules
+}
+
+// 从环境变量读取鉴权配置，prefix为变量名前缀，例如prefix为AUTH时读取：
+//
+//	AUTH_NAME、AUTH_CLIENT_ID、AUTH_CLIENT_SECRET、AUTH_REDIRECT_URI、AUTH_HOST、
+//	AUTH_AUTO_LOAD_RESOURCE、AUTH_SCOPE、AUTH_CACHE_EXPIRE、
+//	AUTH_URL_CONTROL（格式为 url=rules;method:url=rules）、AUTH_ABAC_RULES（格式同上）
+func ConfigFromEnv(prefix string) *Config {
+	env := func(name string) string {
+		return os.Getenv(prefix + "_" + name)
+	}
+	return &Config{
+		Name:             env("NAME"),
+		ClientId:         env("CLIENT_ID"),
+		ClientSecret:     env("CLIENT_SECRET"),
+		RedirectUri:      env("REDIRECT_URI"),
+		Host:             env("HOST"),
+		AutoLoadResource: env("AUTO_LOAD_RESOURCE"),
+		Scope:            env("SCOPE"),
+		CacheExpire:      env("CACHE_EXPIRE"),
+		UrlControl:       parseRuleList(env("URL_CONTROL")),
+		AbacRules:        parseRuleList(env("ABAC_RULES")),
+	}
+}
+
+// 从环境变量读取sso接口配置，例如prefix为SSO时读取：
+//
+//	SSO_CLIENT_ID、SSO_CLIENT_SECRET、SSO_REDIRECT_URI、SSO_API_HOST
+func ApiConfigFromEnv(prefix string) *ApiConfig {
+	env := func(name string) string {
+		return os.Getenv(prefix + "_" + name)
+	}
+	return &ApiConfig{
+		ClientId:     env("CLIENT_ID"),
+		ClientSecret: env("CLIENT_SECRET"),
+		RedirectUri:  env("REDIRECT_URI"),
+		ApiHost:      env("API_HOST"),
+	}
+}
+
+// 从beego配置（如beego.AppConfig）的section中读取鉴权配置，例如section为auth时：
+//
+//	[auth]
+//	clientId = 1
+//	clientSecret = xxx
+//	redirectUri = http://app/callback
+//	host = https://sso
+//	autoLoadResource = true
+//	cacheExpire = 300
+//
+//	[auth.urlcontrol]
+//	/admin/* = admin
+//	post:/orders = order:edit|role:ops
+//
+//	[auth.abacrules]
+//	put:/orders/:tenant = order:edit when order.tenant == path.tenant
+//
+// section名称不区分大小写（beego的ini解析器会转为小写）。section不存在时返回错误；
+// 规则默认读取section.urlcontrol和section.abacrules，不存在时为空；
+// 也可以在section中以urlControl、abacRules指定规则所在的section，此时该section必须存在。
+func ConfigFromAppConfig(conf config.Configer, section string) (*Config, error) {
+	section, _, ok := findSection(conf, section)
+	if !ok {
+		return nil, fmt.Errorf("config section %s not found", section)
+	}
+	get := func(key string) string {
+		return conf.String(section + "::" + key)
+	}
+	c := &Config{
+		Name:             get("name"),
+		ClientId:         get("clientId"),
+		ClientSecret:     get("clientSecret"),
+		RedirectUri:      get("redirectUri"),
+		Host:             get("host"),
+		AutoLoadResource: get("autoLoadResource"),
+		Scope:            get("scope"),
+		CacheExpire:      get("cacheExpire"),
+	}
+	var err error
+	if c.UrlControl, err = ruleSection(conf, get("urlControl"), section+".urlcontrol"); err != nil {
+		return nil, err
+	}
+	if c.AbacRules, err = ruleSection(conf, get("abacRules"), section+".abacrules"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 从beego配置的section中读取sso接口配置，key为clientId、clientSecret、redirectUri、apiHost
+func ApiConfigFromAppConfig(conf config.Configer, section string) *ApiConfig {
+	get := func(key string) string {
+		return conf.String(section + "::" + key)
+	}
+	return &ApiConfig{
+		ClientId:     get("clientId"),
+		ClientSecret: get("clientSecret"),
+		RedirectUri:  get("redirectUri"),
+		ApiHost:      get("apiHost"),
+	}
+}
+
+// 查找section，先按原名称再按小写名称，返回实际的名称
+// Configer.GetSection只在section不存在时返回错误（各实现的错误内容不同），因此出错即视为不存在
+func findSection(conf config.Configer, section string) (string, map[string]string, bool) {
+	if values, err := conf.GetSection(section); err == nil {
+		return section, values, true
+	}
+	lower := strings.ToLower(section)
+	if values, err := conf.GetSection(lower); err == nil {
+		return lower, values, true
+	}
+	return section, nil, false
+}
+
+// 读取规则section：指定了名称时必须存在，否则读取默认名称，不存在时返回空map
+func ruleSection(conf config.Configer, named, fallback string) (map[string]string, error) {
+	if named != "" {
+		if _, values, ok := findSection(conf, named); ok {
+			return values, nil
+		}
+		return nil, fmt.Errorf("config section %s not found", named)
+	}
+	if _, values, ok := findSection(conf, fallback); ok {
+		return values, nil
+	}
+	return map[string]string{}, nil
+}
+
+// 规则为role:或roletype:时需要加载用户角色，rule需已转为小写
+func isRoleRule(rule string) bool {
+	return strings.HasPrefix(rule, RULE_PREFIX_ROLE) || strings.HasPrefix(rule, RULE_PREFIX_ROLE_TYPE)
+}
+
+// 运行时追加UrlControl规则（如嵌入的管理页面），需在处理请求前调用；角色规则会开启AutoLoadRole
+func (a *Auth) AddUrlControl(key string, rules ...string) {
+	lower := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if isRoleRule(rule) {
+			a.AutoLoadRole = true
+		}
+		lower = append(lower, rule)
+	}
+	if a.UrlControl == nil {
+		a.UrlControl = make(map[string][]string)
+	}
+	a.UrlControl[strings.ToLower(key)] = lower
+}
@@ -0,0 +1,101 @@
+package filter
+
+import (
+	"github.com/astaxie/beego/config"
+	"testing"
+)
+
+const testAppConfig = `
+[MySSO]
+clientId = client
+host = http://sso.example.com
+
+[MySSO.urlcontrol]
+/orders = order:view
+
+[custom]
+clientId = custom
+urlControl = Custom.Rules
+
+[custom.rules]
+/custom = custom:view
+
+[broken]
+clientId = broken
+abacRules = missing.rules
+`
+
+func TestConfigFromAppConfig(t *testing.T) {
+	conf, err := config.NewConfigData("ini", []byte(testAppConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name       string
+		section    string
+		clientId   string
+		urlControl map[string]string
+		wantErr    bool
+	}{
+		{name: "mixed case", section: "MySSO", clientId: "client", urlControl: map[string]string{"/orders": "order:view"}},
+		{name: "lower case", section: "mysso", clientId: "client", urlControl: map[string]string{"/orders": "order:view"}},
+		{name: "named rule section", section: "custom", clientId: "custom", urlControl: map[string]string{"/custom": "custom:view"}},
+		{name: "missing section", section: "nosuch", wantErr: true},
+		{name: "missing named rule section", section: "broken", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ConfigFromAppConfig(conf, c.section)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ClientId != c.clientId {
+				t.Errorf("ClientId = %q, want %q", got.ClientId, c.clientId)
+			}
+			if len(got.UrlControl) != len(c.urlControl) {
+				t.Fatalf("UrlControl = %v, want %v", got.UrlControl, c.urlControl)
+			}
+			for k, v := range c.urlControl {
+				if got.UrlControl[k] != v {
+					t.Errorf("UrlControl[%s] = %q, want %q", k, got.UrlControl[k], v)
+				}
+			}
+			if got.AbacRules == nil {
+				t.Error("AbacRules should default to an empty map")
+			}
+		})
+	}
+}
+
+func TestNewAuthImpersonateRole(t *testing.T) {
+	cases := []struct {
+		resource string
+		want     bool
+	}{
+		{"Role:Admin", true},
+		{"roletype:owner@ops", true},
+		{"user:impersonate", false},
+	}
+	for _, c := range cases {
+		a, err := newAuth(&Config{
+			ClientId:            "1",
+			ClientSecret:        "secret",
+			RedirectUri:         "http://app.example.com/callback",
+			Host:                "http://sso.example.com",
+			ApiAuth:             &fakeRbacApi{},
+			ImpersonateResource: c.resource,
+		}, false)
+		if err != nil {
+			t.Fatalf("%s: %v", c.resource, err)
+		}
+		if a.AutoLoadRole != c.want {
+			t.Errorf("%s: AutoLoadRole = %v, want %v", c.resource, a.AutoLoadRole, c.want)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package filter
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego/context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_COOKIE_CHUNK_SIZE = 3800 // 单个cookie值的最大长度，留出名称和属性的空间
+	DEFAULT_COOKIE_MAX_CHUNKS = 4
+	DEFAULT_COOKIE_LIFETIME   = 24 * 3600 // MaxAge为0时cookie用户的有效期（秒）
+	cookieChunkMarker         = "~"       // 分片时主cookie的值为 ~分片数
+	cookieDataPrefix          = "_cookie_user:"
+)
+
+var (
+	ErrCookieTooLarge = errors.New("cookie user is too large")
+	errCookieInvalid  = errors.New("cookie user is invalid")
+	cookieNameInvalid = regexp.MustCompile(`[^A-Za-z0-9_\-]`)
+)
+
+// 将登录用户加密保存在cookie中（AES-GCM，同时校验完整性），不依赖共享的session后端
+//
+// cookie中保存用户Id、token、资源和角色；超出大小时省略资源和角色，只保留资源摘要，
+// 读取时CacheTime置0，由CheckLoginFilter向sso重新加载。
+//
+// 多个key时第一个用于加密，其余仅用于解密，轮换时将新key放在最前面，旧key保留到cookie过期后再移除。
+//
+// cookie中记录过期时间（MaxAge，为0时为Lifetime），过期的cookie即使被客户端保留或重放也视为不存在。
+type CookieUserStore struct {
+	aeads     []cipher.AEAD
+	MaxAge    int // cookie有效期（秒），0为浏览器会话期间
+	Lifetime  int // MaxAge为0时服务端认可的最长有效期（秒），默认DEFAULT_COOKIE_LIFETIME
+	Path      string
+	Domain    string
+	Secure    bool
+	ChunkSize int // 单个cookie值的最大长度
+	MaxChunks int // 最多分片数，超出时省略资源和角色
+}
+
+var _ UserStore = (*CookieUserStore)(nil)
+
+// keys为16、24或32字节的AES key，第一个用于加密
+func NewCookieUserStore(keys ...[]byte) (*CookieUserStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("cookie user store requires at least one key")
+	}
+	store := &CookieUserStore{
+		Lifetime:  DEFAULT_COOKIE_LIFETIME,
+		Path:      "/",
+		ChunkSize: DEFAULT_COOKIE_CHUNK_SIZE,
+		MaxChunks: DEFAULT_COOKIE_MAX_CHUNKS,
+	}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("cookie key %d: %v", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("cookie key %d: %v", i, err)
+		}
+		store.aeads = append(store.aeads, aead)
+	}
+	return store, nil
+}
+
+// cookie中保存的用户，字段名尽量短以减小cookie大小
+type cookieUser struct {
+	Id             string            `json:"i"`
+	Fullname       string            `json:"n,omitempty"`
+	Dn             string            `json:"dn,omitempty"`
+	Attrs          map[string]string `json:"at,omitempty"`
+	Token          cookieToken       `json:"t"`
+	CacheTime      int64             `json:"c"`
+	LoadTime       int64             `json:"l,omitempty"`
+	Digest         string            `json:"d"`
+	Resources      []string          `json:"r,omitempty"`
+	Roles          []cookieRole      `json:"ro,omitempty"`
+	Omitted        bool              `json:"o,omitempty"` // 资源和角色因大小限制被省略
+	ImpersonatedBy string            `json:"ib,omitempty"`
+	Exp            int64             `json:"x"` // 过期时间（unix秒）
+}
+
+type cookieToken struct {
+	AccessToken  string `json:"a,omitempty"`
+	RefreshToken string `json:"r,omitempty"`
+	TokenType    string `json:"y,omitempty"`
+	ExpiresIn    int64  `json:"e,omitempty"`
+	Scope        string `json:"s,omitempty"`
+}
+
+type cookieRole struct {
+	Id       int    `json:"i"`
+	Name     string `json:"n"`
+	ParentId int    `json:"p,omitempty"`
+	RoleType string `json:"t,omitempty"`
+}
+
+// 资源摘要：排序后资源Data的sha256前16字节
+func resourceDigest(resources []*Resource) string {
+	data := make([]string, 0, len(resources))
+	for _, r := range resources {
+		data = append(data, r.Data)
+	}
+	sort.Strings(data)
+	sum := sha256.Sum256([]byte(strings.Join(data, "\n")))
+	return hex.EncodeToString(sum[:16])
+}
+
+func newCookieUser(user User, exp int64) *cookieUser {
+	c := &cookieUser{
+		Exp:       exp,
+		Id:        user.Id,
+		Fullname:  user.Fullname,
+		Dn:        user.Dn,
+		Attrs:     user.Attrs,
+		CacheTime: user.CacheTime,
+		LoadTime:  user.LoadTime,
+		Digest:    resourceDigest(user.Resources),
+		Token: cookieToken{
+			AccessToken:  user.Token.AccessToken,
+			RefreshToken: user.Token.RefreshToken,
+			TokenType:    user.Token.TokenType,
+			ExpiresIn:    user.Token.ExpiresIn,
+			Scope:        user.Token.Scope,
+		},
+		ImpersonatedBy: user.ImpersonatedBy,
+	}
+	for _, r := range user.Resources {
+		c.Resources = append(c.Resources, r.Data)
+	}
+	for _, r := range user.Roles {
+		c.Roles = append(c.Roles, cookieRole{Id: r.Id, Name: r.Name, ParentId: r.ParentId, RoleType: r.RoleType})
+	}
+	return c
+}
+
+func (c *cookieUser) user() User {
+	user := User{
+		Id:       c.Id,
+		Fullname: c.Fullname,
+		Dn:       c.Dn,
+		Attrs:    c.Attrs,
+		Token: Token{
+			AccessToken:  c.Token.AccessToken,
+			RefreshToken: c.Token.RefreshToken,
+			TokenType:    c.Token.TokenType,
+			ExpiresIn:    c.Token.ExpiresIn,
+			Scope:        c.Token.Scope,
+		},
+		ImpersonatedBy: c.ImpersonatedBy,
+	}
+	resources := make([]*Resource, 0, len(c.Resources))
+	for _, data := range c.Resources {
+		resources = append(resources, &Resource{Data: data})
+	}
+	user.setResources(resources)
+	if len(c.Roles) > 0 {
+		roles := make([]*UserRole, 0, len(c.Roles))
+		for _, r := range c.Roles {
+			roles = append(roles, &UserRole{Id: r.Id, Name: r.Name, ParentId: r.ParentId, RoleType: r.RoleType})
+		}
+		user.setRoles(roles)
+	}
+	user.CacheTime = c.CacheTime
+	user.LoadTime = c.LoadTime
+	// 资源被省略或与摘要不一致时，由CheckLoginFilter重新加载
+	if c.Omitted || resourceDigest(user.Resources) != c.Digest {
+		user.CacheTime = 0
+		user.LoadTime = 0
+	}
+	return user
+}
+
+// cookie名称只保留字母、数字、_和-
+func cookieName(key string) string {
+	return cookieNameInvalid.ReplaceAllString(key, "_")
+}
+
+func (s *CookieUserStore) seal(name string, c *cookieUser) (string, error) {
+	plain, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	// 以cookie名称作为附加数据，防止不同cookie之间互换
+	sealed := aead.Seal(nonce, nonce, plain, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (s *CookieUserStore) open(name, value string) (*cookieUser, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errCookieInvalid
+	}
+	for _, aead := range s.aeads {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(name))
+		if err != nil {
+			continue
+		}
+		c := &cookieUser{}
+		if err := json.Unmarshal(plain, c); err != nil {
+			return nil, errCookieInvalid
+		}
+		// 没有过期时间的cookie同样视为过期
+		if c.Exp <= time.Now().Unix() {
+			return nil, errCookieInvalid
+		}
+		return c, nil
+	}
+	return nil, errCookieInvalid
+}
+
+func (s *CookieUserStore) chunkSize() int {
+	if s.ChunkSize <= 0 {
+		return DEFAULT_COOKIE_CHUNK_SIZE
+	}
+	return s.ChunkSize
+}
+
+// cookie用户的有效期（秒）
+func (s *CookieUserStore) lifetime() int {
+	if s.MaxAge > 0 {
+		return s.MaxAge
+	}
+	if s.Lifetime <= 0 {
+		return DEFAULT_COOKIE_LIFETIME
+	}
+	return s.Lifetime
+}
+
+func (s *CookieUserStore) maxChunks() int {
+	if s.MaxChunks <= 0 {
+		return 1
+	}
+	return s.MaxChunks
+}
+
+func (s *CookieUserStore) Get(ctx *context.Context, key string) (User, bool) {
+	name := cookieName(key)
+	// 本次请求中已写入的用户，请求中的cookie此时仍是旧值
+	if data := ctx.Input.GetData(cookieDataPrefix + name); data != nil {
+		user, ok := data.(User)
+		return user, ok
+	}
+	value := ctx.GetCookie(name)
+	if value == "" {
+		return User{}, false
+	}
+	if strings.HasPrefix(value, cookieChunkMarker) {
+		n, err := strconv.Atoi(strings.TrimPrefix(value, cookieChunkMarker))
+		if err != nil || n <= 0 || n > s.maxChunks() {
+			return User{}, false
+		}
+		parts := make([]string, 0, n)
+		for i := 1; i <= n; i++ {
+			part := ctx.GetCookie(fmt.Sprintf("%s_%d", name, i))
+			if part == "" {
+				return User{}, false
+			}
+			parts = append(parts, part)
+		}
+		value = strings.Join(parts, "")
+	}
+	c, err := s.open(name, value)
+	if err != nil {
+		return User{}, false
+	}
+	return c.user(), true
+}
+
+func (s *CookieUserStore) Set(ctx *context.Context, key string, user User) error {
+	name := cookieName(key)
+	c := newCookieUser(user, time.Now().Unix()+int64(s.lifetime()))
+	value, err := s.seal(name, c)
+	if err != nil {
+		return err
+	}
+	size, max := s.chunkSize(), s.maxChunks()
+	if len(value) > size*max {
+		// 超出大小时省略资源和角色，读取时重新加载
+		c.Resources, c.Roles, c.Omitted = nil, nil, true
+		if value, err = s.seal(name, c); err != nil {
+			return err
+		}
+		if len(value) > size*max {
+			return ErrCookieTooLarge
+		}
+	}
+
+	old := s.chunkCount(ctx, name)
+	if len(value) <= size {
+		s.setCookie(ctx, name, value, s.MaxAge)
+		s.deleteChunks(ctx, name, 1, old)
+	} else {
+		n := 0
+		for start := 0; start < len(value); start += size {
+			end := start + size
+			if end > len(value) {
+				end = len(value)
+			}
+			n++
+			s.setCookie(ctx, fmt.Sprintf("%s_%d", name, n), value[start:end], s.MaxAge)
+		}
+		s.setCookie(ctx, name, cookieChunkMarker+strconv.Itoa(n), s.MaxAge)
+		s.deleteChunks(ctx, name, n+1, old)
+	}
+	ctx.Input.SetData(cookieDataPrefix+name, user)
+	return nil
+}
+
+func (s *CookieUserStore) Delete(ctx *context.Context, key string) {
+	name := cookieName(key)
+	s.deleteChunks(ctx, name, 1, s.chunkCount(ctx, name))
+	s.setCookie(ctx, name, "", -1)
+	ctx.Input.SetData(cookieDataPrefix+name, false)
+}
+
+// 请求中已有的分片数
+func (s *CookieUserStore) chunkCount(ctx *context.Context, name string) int {
+	value := ctx.GetCookie(name)
+	if !strings.HasPrefix(value, cookieChunkMarker) {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimPrefix(value, cookieChunkMarker))
+	if n > s.maxChunks() {
+		n = s.maxChunks()
+	}
+	return n
+}
+
+// 删除序号from到to的分片
+func (s *CookieUserStore) deleteChunks(ctx *context.Context, name string, from, to int) {
+	for i := from; i <= to; i++ {
+		s.setCookie(ctx, fmt.Sprintf("%s_%d", name, i), "", -1)
+	}
+}
+
+func (s *CookieUserStore) setCookie(ctx *context.Context, name, value string, maxAge int) {
+	ctx.SetCookie(name, value, maxAge, s.Path, s.Domain, s.Secure, true)
+}
@@ -0,0 +1,209 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/astaxie/beego/context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestContext(cookies []*http.Cookie) (*context.Context, *httptest.ResponseRecorder) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	ctx := context.NewContext()
+	ctx.Reset(w, r)
+	return ctx, w
+}
+
+// 以writer写入用户，经mutate修改响应中的cookie后由reader在新请求中读取
+func cookieRoundTrip(t *testing.T, writer, reader *CookieUserStore, user User, mutate func([]*http.Cookie) []*http.Cookie) (User, bool, error) {
+	ctx, w := newTestContext(nil)
+	if err := writer.Set(ctx, "auth_user", user); err != nil {
+		return User{}, false, err
+	}
+	var cookies []*http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge >= 0 && c.Value != "" {
+			cookies = append(cookies, c)
+		}
+	}
+	if mutate != nil {
+		cookies = mutate(cookies)
+	}
+	next, _ := newTestContext(cookies)
+	got, ok := reader.Get(next, "auth_user")
+	return got, ok, nil
+}
+
+func mustCookieStore(t *testing.T, keys ...[]byte) *CookieUserStore {
+	store, err := NewCookieUserStore(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestNewCookieUserStoreKeys(t *testing.T) {
+	cases := []struct {
+		keys    [][]byte
+		wantErr bool
+	}{
+		{nil, true},
+		{[][]byte{make([]byte, 16)}, false},
+		{[][]byte{make([]byte, 24), make([]byte, 32)}, false},
+		{[][]byte{make([]byte, 15)}, true},
+		{[][]byte{make([]byte, 32), []byte("short")}, true},
+	}
+	for i, c := range cases {
+		if _, err := NewCookieUserStore(c.keys...); (err != nil) != c.wantErr {
+			t.Errorf("case %d: error = %v, wantErr %v", i, err, c.wantErr)
+		}
+	}
+}
+
+func TestCookieUserStoreRoundTrip(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	user := User{Id: "tom", Fullname: "Tom", Attrs: map[string]string{"region": "cn"}, Token: Token{AccessToken: "at", RefreshToken: "rt"}}
+	user.setResources([]*Resource{{Id: 1, Data: "order:view"}, {Id: 2, Data: `{"key":"order:edit","attrs":{"tenant":"t1"}}`}})
+	user.setRoles([]*UserRole{{Id: 1, Name: "ops", RoleType: "admin"}})
+
+	many := user
+	var resources []*Resource
+	for i := 0; i < 200; i++ {
+		resources = append(resources, &Resource{Id: int64(i), Data: fmt.Sprintf("resource:%d:with-some-padding", i)})
+	}
+	many.setResources(resources)
+
+	small := mustCookieStore(t, newKey)
+	small.ChunkSize, small.MaxChunks = 1000, 16
+	single := mustCookieStore(t, newKey)
+	single.MaxChunks = 1
+	// 以其他名称写入的值，不能在auth_user下解密
+	ctx, w := newTestContext(nil)
+	if err := mustCookieStore(t, newKey).Set(ctx, "other_user", user); err != nil {
+		t.Fatal(err)
+	}
+	otherValue := w.Result().Cookies()[0].Value
+
+	cases := []struct {
+		name       string
+		writer     *CookieUserStore
+		reader     *CookieUserStore
+		user       User
+		mutate     func([]*http.Cookie) []*http.Cookie
+		wantOk     bool
+		wantReload bool // 资源被省略，CacheTime为0
+		wantErr    error
+	}{
+		{name: "round trip", writer: mustCookieStore(t, newKey), reader: mustCookieStore(t, newKey), user: user, wantOk: true},
+		{name: "chunked", writer: small, reader: small, user: many, wantOk: true},
+		{name: "rotated key", writer: mustCookieStore(t, oldKey), reader: mustCookieStore(t, newKey, oldKey), user: user, wantOk: true},
+		{name: "removed key", writer: mustCookieStore(t, oldKey), reader: mustCookieStore(t, newKey), user: user},
+		{name: "tampered", writer: mustCookieStore(t, newKey), reader: mustCookieStore(t, newKey), user: user, mutate: func(cs []*http.Cookie) []*http.Cookie {
+			v := []byte(cs[0].Value)
+			v[len(v)/2] ^= 1
+			cs[0].Value = string(v)
+			return cs
+		}},
+		{name: "renamed", writer: mustCookieStore(t, newKey), reader: mustCookieStore(t, newKey), user: user, mutate: func(cs []*http.Cookie) []*http.Cookie {
+			return []*http.Cookie{{Name: "auth_user", Value: otherValue}}
+		}},
+		{name: "missing chunk", writer: small, reader: small, user: many, mutate: func(cs []*http.Cookie) []*http.Cookie {
+			var kept []*http.Cookie
+			for _, c := range cs {
+				if c.Name != "auth_user_2" {
+					kept = append(kept, c)
+				}
+			}
+			return kept
+		}},
+		{name: "omitted when too large", writer: single, reader: single, user: many, wantOk: true, wantReload: true},
+	}
+
+	for _, c := range cases {
+		got, ok, err := cookieRoundTrip(t, c.writer, c.reader, c.user, c.mutate)
+		if err != c.wantErr {
+			t.Errorf("%s: Set error %v, want %v", c.name, err, c.wantErr)
+			continue
+		}
+		if ok != c.wantOk {
+			t.Errorf("%s: Get ok = %v, want %v", c.name, ok, c.wantOk)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got.Id != c.user.Id || got.Token.AccessToken != c.user.Token.AccessToken || !reflect.DeepEqual(got.Attrs, c.user.Attrs) {
+			t.Errorf("%s: got user %+v", c.name, got)
+		}
+		if c.wantReload {
+			if got.CacheTime != 0 || len(got.Resources) != 0 {
+				t.Errorf("%s: omitted resources should be reloaded, CacheTime %d resources %d", c.name, got.CacheTime, len(got.Resources))
+			}
+			continue
+		}
+		if got.CacheTime != c.user.CacheTime || len(got.Resources) != len(c.user.Resources) {
+			t.Errorf("%s: CacheTime %d resources %d, want %d %d", c.name, got.CacheTime, len(got.Resources), c.user.CacheTime, len(c.user.Resources))
+		}
+		if _, ok := got.ResourceMap["order:edit"]; c.user.ResourceMap["order:edit"] != nil && !ok {
+			t.Errorf("%s: parsed resource key lost", c.name)
+		}
+	}
+}
+
+func TestCookieUserStoreTooLarge(t *testing.T) {
+	store := mustCookieStore(t, make([]byte, 16))
+	store.ChunkSize, store.MaxChunks = 100, 1
+	user := User{Id: "tom", Attrs: map[string]string{"bio": string(bytes.Repeat([]byte("x"), 500))}}
+	if _, _, err := cookieRoundTrip(t, store, store, user, nil); err != ErrCookieTooLarge {
+		t.Fatalf("Set error = %v, want ErrCookieTooLarge", err)
+	}
+}
+
+func TestCookieUserStoreExpiry(t *testing.T) {
+	store := mustCookieStore(t, make([]byte, 16))
+	now := time.Now().Unix()
+	cases := []struct {
+		name string
+		exp  int64
+		ok   bool
+	}{
+		{"valid", now + 60, true},
+		{"expired", now - 1, false},
+		{"missing exp", 0, false},
+	}
+	for _, c := range cases {
+		value, err := store.seal("auth_user", newCookieUser(User{Id: "tom"}, c.exp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, _ := newTestContext([]*http.Cookie{{Name: "auth_user", Value: value}})
+		if _, ok := store.Get(ctx, "auth_user"); ok != c.ok {
+			t.Errorf("%s: Get ok = %v, want %v", c.name, ok, c.ok)
+		}
+	}
+}
+
+func TestCookieUserStoreLifetime(t *testing.T) {
+	store := mustCookieStore(t, make([]byte, 16))
+	cases := []struct {
+		maxAge, lifetime, want int
+	}{
+		{0, 0, DEFAULT_COOKIE_LIFETIME},
+		{0, 600, 600},
+		{3600, 600, 3600},
+	}
+	for _, c := range cases {
+		store.MaxAge, store.Lifetime = c.maxAge, c.lifetime
+		if got := store.lifetime(); got != c.want {
+			t.Errorf("MaxAge %d Lifetime %d: lifetime = %d, want %d", c.maxAge, c.lifetime, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 授权来源
+const (
+	GRANT_DIRECT    = "direct"    // 用户所在角色直接关联该资源
+	GRANT_INHERITED = "inherited" // 从祖先角色继承
+	GRANT_ROLE_TYPE = "role_type" // 用户在角色中的类型满足roletype:规则
+)
+
+// 用户获得资源的一条路径
+type Grant struct {
+	Kind     string `json:"kind"`      // direct、inherited或role_type
+	RoleId   int    `json:"role_id"`   // 用户直接所在的角色
+	RoleName string `json:"role_name"` //
+	RoleType string `json:"role_type"` // 用户在该角色中的类型
+	ViaId    int    `json:"via_id"`    // 关联该资源的角色，direct时与RoleId相同；role_type时为规则限定的角色（未限定时与RoleId相同）
+	ViaName  string `json:"via_name"`  //
+	Path     string `json:"path"`      // 用户所在角色的路径，如 root/ops/oncall
+}
+
+func (g *Grant) String() string {
+	s := fmt.Sprintf("%s via role %s", g.Kind, g.Path)
+	if g.Kind == GRANT_INHERITED || g.Kind == GRANT_ROLE_TYPE && g.ViaId != g.RoleId {
+		s += fmt.Sprintf(" (inherited from %s)", g.ViaName)
+	}
+	if g.RoleType != "" {
+		s += fmt.Sprintf(" as %s", g.RoleType)
+	}
+	return s
+}
+
+// 加入后可获得资源的角色
+type GrantCandidate struct {
+	RoleId   int    `json:"role_id"`
+	Path     string `json:"path"`
+	ViaId    int    `json:"via_id"` // 关联该资源的角色，自身或祖先角色；roletype:规则时为限定的角色
+	ViaName  string `json:"via_name"`
+	RoleType string `json:"role_type,omitempty"` // roletype:规则时需以该类型加入
+}
+
+// 用户与资源之间的授权说明
+type Explanation struct {
+	UserId     string            `json:"user_id"`
+	Resource   *ApiResource      `json:"resource,omitempty"`
+	Rule       string            `json:"rule,omitempty"` // roletype:规则，此时Resource为空
+	Granted    bool              `json:"granted"`
+	Grants     []*Grant          `json:"grants,omitempty"`     // 全部授权路径
+	Candidates []*GrantCandidate `json:"candidates,omitempty"` // 未授权时可获得该资源的角色
+}
+
+func (e *Explanation) String() string {
+	target := e.Rule
+	if e.Resource != nil {
+		target = e.Resource.Data
+	}
+	lines := []string{}
+	if e.Granted {
+		lines = append(lines, fmt.Sprintf("%s has %s:", e.UserId, target))
+		for _, g := range e.Grants {
+			lines = append(lines, "  "+g.String())
+		}
+	} else {
+		lines = append(lines, fmt.Sprintf("%s does not have %s, granted by joining:", e.UserId, target))
+		for _, c := range e.Candidates {
+			line := fmt.Sprintf("  %s (from %s)", c.Path, c.ViaName)
+			if c.RoleType != "" {
+				line += " as " + c.RoleType
+			}
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 解释用户为何拥有或缺少某个资源
+type Explainer struct {
+	api ApiAuthService
+}
+
+func NewExplainer(api ApiAuthService) *Explainer {
+	return &Explainer{api: api}
+}
+
+// resource为资源id或资源Data
+func (x *Explainer) findResource(resource string) (*ApiResource, error) {
+	resources, err := x.api.GetAllResources()
+	if err != nil {
+		return nil, err
+	}
+	id, idErr := strconv.Atoi(resource)
+	for _, r := range resources {
+		if (idErr == nil && r.Id == id) || strings.EqualFold(r.Data, resource) {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("resource %s not found", resource)
+}
+
+// 关联了该资源的角色id
+func (x *Explainer) rolesWithResource(resourceId int) (map[int]bool, error) {
+	infos, err := x.api.GetAllRelatedInfo()
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[int]bool)
+	for _, info := range infos {
+		if info.ResourceId == resourceId {
+			roles[info.RoleId] = true
+		}
+	}
+	return roles, nil
+}
+
+// 列出用户获得资源的全部路径；未获得时同时给出可获得该资源的角色
+// resource为roletype:type[@role]规则时，列出用户以该类型所在的角色
+func (x *Explainer) Explain(userId, resource string) (*Explanation, error) {
+	if strings.HasPrefix(strings.ToLower(resource), RULE_PREFIX_ROLE_TYPE) {
+		return x.explainRoleType(userId, strings.ToLower(resource))
+	}
+	res, err := x.findResource(resource)
+	if err != nil {
+		return nil, err
+	}
+	holders, err := x.rolesWithResource(res.Id)
+	if err != nil {
+		return nil, err
+	}
+	trees, err := x.api.GetRoleTree(false, false)
+	if err != nil {
+		return nil, err
+	}
+	forest := RoleForest(trees)
+	roles, err := x.api.GetUserRoles(userId, false, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Explanation{UserId: userId, Resource: res}
+	for _, role := range roles {
+		node := forest.Find(role.Id)
+		if node == nil {
+			continue
+		}
+		// 用户所在角色继承全部祖先角色的资源
+		path := append(forest.Ancestors(role.Id), node)
+		for _, via := range path {
+			if !holders[via.Id] {
+				continue
+			}
+			kind := GRANT_INHERITED
+			if via.Id == role.Id {
+				kind = GRANT_DIRECT
+			}
+			e.Grants = append(e.Grants, &Grant{
+				Kind:     kind,
+				RoleId:   role.Id,
+				RoleName: role.Name,
+				RoleType: role.RoleType,
+				ViaId:    via.Id,
+				ViaName:  via.Name,
+				Path:     forest.Path(role.Id),
+			})
+		}
+	}
+	e.Granted = len(e.Grants) > 0
+	if !e.Granted {
+		e.Candidates = candidates(forest, holders)
+	}
+	return e, nil
+}
+
+// 与User.satisfies一致：用户在某角色中的类型为type时满足roletype:type，
+// 该角色或其祖先角色名为role时满足roletype:type@role
+func (x *Explainer) explainRoleType(userId, rule string) (*Explanation, error) {
+	roleType := strings.TrimPrefix(rule, RULE_PREFIX_ROLE_TYPE)
+	scope := ""
+	if idx := strings.Index(roleType, "@"); idx >= 0 {
+		roleType, scope = roleType[:idx], roleType[idx+1:]
+	}
+	if roleType == "" {
+		return nil, fmt.Errorf("invalid rule %s", rule)
+	}
+	trees, err := x.api.GetRoleTree(false, false)
+	if err != nil {
+		return nil, err
+	}
+	forest := RoleForest(trees)
+	roles, err := x.api.GetUserRoles(userId, false, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Explanation{UserId: userId, Rule: rule}
+	for _, role := range roles {
+		if !strings.EqualFold(role.RoleType, roleType) {
+			continue
+		}
+		node := forest.Find(role.Id)
+		if node == nil {
+			continue
+		}
+		via := node
+		if scope != "" {
+			via = nil
+			for _, n := range append(forest.Ancestors(role.Id), node) {
+				if strings.EqualFold(n.Name, scope) {
+					via = n
+				}
+			}
+			if via == nil {
+				continue
+			}
+		}
+		e.Grants = append(e.Grants, &Grant{
+			Kind:     GRANT_ROLE_TYPE,
+			RoleId:   role.Id,
+			RoleName: role.Name,
+			RoleType: role.RoleType,
+			ViaId:    via.Id,
+			ViaName:  via.Name,
+			Path:     forest.Path(role.Id),
+		})
+	}
+	e.Granted = len(e.Grants) > 0
+	if !e.Granted {
+		holders := make(map[int]bool)
+		forest.Walk(func(node *RoleTree, depth int) error {
+			if scope == "" || strings.EqualFold(node.Name, scope) {
+				holders[node.Id] = true
+			}
+			return nil
+		})
+		e.Candidates = candidates(forest, holders)
+		for _, c := range e.Candidates {
+			c.RoleType = roleType
+			if scope == "" {
+				// 未限定角色时加入任一角色即可
+				c.ViaId, c.ViaName = c.RoleId, forest.Find(c.RoleId).Name
+			}
+		}
+	}
+	return e, nil
+}
+
+// 用户缺少该资源时返回可获得该资源的角色，已拥有时返回空
+func (x *Explainer) ExplainMissing(userId, resource string) ([]*GrantCandidate, error) {
+	e, err := x.Explain(userId, resource)
+	if err != nil {
+		return nil, err
+	}
+	return e.Candidates, nil
+}
+
+// 关联了资源的角色及其后代角色
+func candidates(forest RoleForest, holders map[int]bool) []*GrantCandidate {
+	var result []*GrantCandidate
+	seen := make(map[int]bool)
+	ids := make([]int, 0, len(holders))
+	for id := range holders {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		holder := forest.Find(id)
+		if holder == nil {
+			continue
+		}
+		for _, node := range append([]*RoleTree{holder}, forest.Descendants(id)...) {
+			if seen[node.Id] {
+				continue
+			}
+			seen[node.Id] = true
+			result = append(result, &GrantCandidate{RoleId: node.Id, Path: forest.Path(node.Id), ViaId: holder.Id, ViaName: holder.Name})
+		}
+	}
+	return result
+}
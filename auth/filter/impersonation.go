@@ -0,0 +1,120 @@
+package filter
+
+import (
+	"errors"
+	"github.com/astaxie/beego/context"
+	"strings"
+)
+
+const (
+	HEADER_IMPERSONATED_BY = "X-Impersonated-By" // 模拟登录期间响应头中的管理员Id
+	DATA_KEY_IMPERSONATOR  = "Impersonator"      // 模拟登录期间写入请求上下文的管理员，模板中可用于显示提示条
+)
+
+var (
+	ErrImpersonationDisabled = errors.New("impersonation is not configured")
+	ErrAlreadyImpersonating  = errors.New("already impersonating another user")
+	ErrNotImpersonating      = errors.New("not impersonating")
+)
+
+// 支持模拟登录的AuthService，Auth和MultiAuth均已实现
+//
+//	if s, ok := service.(filter.ImpersonationService); ok {
+//		err = s.StartImpersonation(ctx, userId)
+//	}
+type ImpersonationService interface {
+	AuthService
+	StartImpersonation(ctx *context.Context, userId string) error
+	StopImpersonation(ctx *context.Context) error
+}
+
+var (
+	_ ImpersonationService = (*Auth)(nil)
+	_ ImpersonationService = (*MultiAuth)(nil)
+)
+
+// 模拟登录时管理员在session中的key
+func (a *Auth) impersonatorKey() string {
+	return a.sessionKey() + ":impersonator"
+}
+
+// 当前模拟登录的管理员，未模拟登录时返回false
+func (a *Auth) Impersonator(ctx *context.Context) (User, bool) {
+	user, ok := a.users().Get(ctx, a.impersonatorKey())
+	return user, ok
+}
+
+// 管理员以指定用户的身份访问（模拟登录），需拥有ImpersonateResource对应的权限
+// 模拟期间session中的用户替换为目标用户，其资源和角色通过ApiAuthService加载
+func (a *Auth) StartImpersonation(ctx *context.Context, userId string) error {
+	if a.ApiAuth == nil || a.ImpersonateResource == "" {
+		return ErrImpersonationDisabled
+	}
+	admin, ok := a.users().Get(ctx, a.sessionKey())
+	if !ok {
+		return ErrNotLogin
+	}
+	if admin.ImpersonatedBy != "" {
+		return ErrAlreadyImpersonating
+	}
+	if missing := admin.missing([]string{a.ImpersonateResource}); len(missing) > 0 {
+		a.audit(ctx, &AuditEvent{Type: AUDIT_IMPERSONATE_START, UserId: admin.Id, Target: userId, Missing: missing, Reason: "permission denied"})
+		return &PermissionError{UserId: admin.Id, Missing: missing}
+	}
+
+	target := User{Id: userId, Fullname: userId, ImpersonatedBy: admin.Id, ResourceMap: make(map[string]*Resource)}
+	if err := a.loadPermissions(&target); err != nil {
+		a.audit(ctx, &AuditEvent{Type: AUDIT_IMPERSONATE_START, UserId: admin.Id, Target: userId, Reason: err.Error()})
+		return err
+	}
+	a.saveUser(ctx, a.impersonatorKey(), admin)
+	a.saveUser(ctx, a.sessionKey(), target)
+	a.exposeImpersonation(ctx, target, admin)
+	a.audit(ctx, &AuditEvent{Type: AUDIT_IMPERSONATE_START, UserId: admin.Id, Target: userId})
+	a.log().Info("impersonation started", F("user_id", admin.Id), F("target", userId))
+	return nil
+}
+
+// 结束模拟登录，恢复管理员自己的session
+func (a *Auth) StopImpersonation(ctx *context.Context) error {
+	admin, ok := a.Impersonator(ctx)
+	if !ok {
+		return ErrNotImpersonating
+	}
+	target, _ := a.users().Get(ctx, a.sessionKey())
+	a.users().Delete(ctx, a.impersonatorKey())
+	a.saveUser(ctx, a.sessionKey(), admin)
+	ctx.Input.SetData(DATA_KEY_USER, admin)
+	a.audit(ctx, &AuditEvent{Type: AUDIT_IMPERSONATE_STOP, UserId: admin.Id, Target: target.Id})
+	a.log().Info("impersonation stopped", F("user_id", admin.Id), F("target", target.Id))
+	return nil
+}
+
+// 模拟登录期间通过响应头和请求上下文标识当前为模拟身份
+func (a *Auth) exposeImpersonation(ctx *context.Context, user, admin User) {
+	ctx.Output.Header(HEADER_IMPERSONATED_BY, admin.Id)
+	ctx.Input.SetData(DATA_KEY_USER, user)
+	ctx.Input.SetData(DATA_KEY_IMPERSONATOR, admin)
+}
+
+// 通过ApiAuthService加载被模拟用户的资源和角色
+func (a *Auth) loadImpersonatedPermissions(user *User) error {
+	if err := loadUserFromApi(a.ApiAuth, user, a.AutoLoadRole); err != nil {
+		return err
+	}
+	user.markLoaded()
+	return nil
+}
+
+// 模拟登录期间被禁止访问的路由
+func (a *Auth) impersonationBlocked(urlPattern, key string) bool {
+	return a.ImpersonationBlock[urlPattern] || a.ImpersonationBlock[key]
+}
+
+func parseImpersonationBlock(routes []string) map[string]bool {
+	block := make(map[string]bool)
+	for _, r := range routes {
+		block[strings.ToLower(r)] = true
+	}
+	return block
+}
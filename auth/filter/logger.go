@@ -0,0 +1,303 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/astaxie/beego/logs"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// 结构化日志字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// 日志接口，可通过Config/ApiConfig注入，默认输出到beego/logs
+// 注入的日志实现同样会经过脱敏处理
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+}
+
+// 输出到beego/logs，字段格式为 key=value
+type beegoLogger struct{}
+
+func formatFields(msg string, fields []Field) string {
+	if len(fields) == 0 {
+		return msg
+	}
+	parts := make([]string, 0, len(fields)+1)
+	parts = append(parts, msg)
+	for _, f := range fields {
+		parts = append(parts, fmt.Sprintf("%s=%+v", f.Key, f.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (beegoLogger) Debug(msg string, fields ...Field) {
+	logs.Debug(formatFields(msg, fields))
+}
+
+func (beegoLogger) Info(msg string, fields ...Field) {
+	logs.Info(formatFields(msg, fields))
+}
+
+func (beegoLogger) Warn(msg string, fields ...Field) {
+	logs.Warn(formatFields(msg, fields))
+}
+
+func (beegoLogger) Error(msg string, fields ...Field) {
+	logs.Error(formatFields(msg, fields))
+}
+
+const REDACTED = "***"
+
+var (
+	// 字段名（小写）以以下内容结尾时整体脱敏，如access_token、clientSecret
+	sensitiveSuffixes = []string{"token", "secret", "password", "authorization", "api_key", "apikey"}
+	// 字段名（小写）等于以下内容时整体脱敏，避免status_code、res_code等被误判
+	sensitiveNames = []string{"code", "auth_code", "authcode"}
+	// 字符串中的敏感参数及Authorization头，参数名前需为单词边界，避免res_code=、error_code=被误判
+	sensitiveParam  = regexp.MustCompile(`(?i)\b((?:access_token|refresh_token|client_secret|client-secret|code)=)[^&\s"]+`)
+	sensitiveBearer = regexp.MustCompile(`(?i)(bearer\s+)[^\s"]+`)
+)
+
+// 对日志字段进行脱敏后交给实际的日志实现
+type redactingLogger struct {
+	inner Logger
+}
+
+// 包装日志实现，屏蔽access/refresh token、client secret和授权code
+func NewRedactingLogger(inner Logger) Logger {
+	if inner == nil {
+		inner = beegoLogger{}
+	}
+	if _, ok := inner.(*redactingLogger); ok {
+		return inner
+	}
+	return &redactingLogger{inner: inner}
+}
+
+var defaultLogger = NewRedactingLogger(nil)
+
+func redactString(s string) string {
+	s = sensitiveParam.ReplaceAllString(s, "${1}"+REDACTED)
+	return sensitiveBearer.ReplaceAllString(s, "${1}"+REDACTED)
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(strings.TrimSpace(key))
+	for _, k := range sensitiveNames {
+		if key == k {
+			return true
+		}
+	}
+	for _, k := range sensitiveSuffixes {
+		if strings.HasSuffix(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return redactString(value)
+	case error:
+		return redactString(value.Error())
+	case Token:
+		return redactToken(value)
+	case *Token:
+		if value == nil {
+			return value
+		}
+		return redactToken(*value)
+	case Client:
+		value.Secret = REDACTED
+		return value
+	case *Client:
+		if value == nil {
+			return value
+		}
+		client := *value
+		client.Secret = REDACTED
+		return client
+	case User:
+		value.Token = redactToken(value.Token)
+		return value
+	case *User:
+		if value == nil {
+			return value
+		}
+		user := *value
+		user.Token = redactToken(user.Token)
+		return user
+	case Config:
+		value.ClientSecret = REDACTED
+		return value
+	case ApiConfig:
+		value.ClientSecret = REDACTED
+		return value
+	case fmt.Stringer:
+		return redactString(value.String())
+	default:
+		return redactReflect(reflect.ValueOf(value), 0)
+	}
+}
+
+// 嵌套超过该深度的值不再展开，避免循环引用
+const MAX_REDACT_DEPTH = 8
+
+// 展开map、struct、slice，敏感的key和字段整体脱敏，其余值递归处理；struct的未导出字段不输出
+func redactReflect(rv reflect.Value, depth int) interface{} {
+	if !rv.IsValid() {
+		return nil
+	}
+	if depth > MAX_REDACT_DEPTH {
+		return REDACTED
+	}
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return redactNested(rv.Elem(), depth)
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			if isSensitiveKey(key) {
+				m[key] = REDACTED
+			} else {
+				m[key] = redactNested(iter.Value(), depth)
+			}
+		}
+		return m
+	case reflect.Struct:
+		t := rv.Type()
+		m := make(map[string]interface{}, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Name
+			if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			if isSensitiveKey(name) || isSensitiveKey(field.Name) {
+				m[name] = REDACTED
+			} else {
+				m[name] = redactNested(rv.Field(i), depth)
+			}
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return redactString(fmt.Sprintf("%s", rv.Interface()))
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = redactNested(rv.Index(i), depth)
+		}
+		return items
+	case reflect.String:
+		return redactString(rv.String())
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return rv.Type().String()
+	default:
+		return rv.Interface()
+	}
+}
+
+// 嵌套的值先按已知类型处理（Token、Client等），再递归展开
+func redactNested(rv reflect.Value, depth int) interface{} {
+	if !rv.IsValid() {
+		return nil
+	}
+	if rv.Kind() == reflect.Interface || rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+	}
+	if rv.CanInterface() {
+		switch v := rv.Interface().(type) {
+		case error, fmt.Stringer, Token, *Token, Client, *Client, User, *User, Config, ApiConfig:
+			return redactValue(v)
+		}
+	}
+	return redactReflect(rv, depth+1)
+}
+
+func redactToken(token Token) Token {
+	if token.AccessToken != "" {
+		token.AccessToken = REDACTED
+	}
+	if token.RefreshToken != "" {
+		token.RefreshToken = REDACTED
+	}
+	return token
+}
+
+func redactFields(fields []Field) []Field {
+	redacted := make([]Field, len(fields))
+	for i, f := range fields {
+		if isSensitiveKey(f.Key) {
+			redacted[i] = Field{Key: f.Key, Value: REDACTED}
+		} else {
+			redacted[i] = Field{Key: f.Key, Value: redactValue(f.Value)}
+		}
+	}
+	return redacted
+}
+
+func (l *redactingLogger) Debug(msg string, fields ...Field) {
+	l.inner.Debug(redactString(msg), redactFields(fields)...)
+}
+
+func (l *redactingLogger) Info(msg string, fields ...Field) {
+	l.inner.Info(redactString(msg), redactFields(fields)...)
+}
+
+func (l *redactingLogger) Warn(msg string, fields ...Field) {
+	l.inner.Warn(redactString(msg), redactFields(fields)...)
+}
+
+func (l *redactingLogger) Error(msg string, fields ...Field) {
+	l.inner.Error(redactString(msg), redactFields(fields)...)
+}
+
+// Logger可能在创建后直接赋值，输出前统一包装脱敏（已包装时不重复包装）
+func (a *Auth) log() Logger {
+	if a.Logger == nil {
+		return defaultLogger
+	}
+	return NewRedactingLogger(a.Logger)
+}
+
+func (a *ApiAuth) log() Logger {
+	if a.Logger == nil {
+		return defaultLogger
+	}
+	return NewRedactingLogger(a.Logger)
+}
@@ -0,0 +1,58 @@
+package filter
+
+import "testing"
+
+// 记录最后一条日志
+type recordingLogger struct {
+	msg    string
+	fields []Field
+}
+
+func (l *recordingLogger) record(msg string, fields []Field) {
+	l.msg, l.fields = msg, fields
+}
+
+func (l *recordingLogger) Debug(msg string, fields ...Field) { l.record(msg, fields) }
+func (l *recordingLogger) Info(msg string, fields ...Field)  { l.record(msg, fields) }
+func (l *recordingLogger) Warn(msg string, fields ...Field)  { l.record(msg, fields) }
+func (l *recordingLogger) Error(msg string, fields ...Field) { l.record(msg, fields) }
+
+func TestRedactString(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"/callback?code=abc&state=x", "/callback?code=***&state=x"},
+		{"code=abc", "code=***"},
+		{"res_code=1 error_code=2", "res_code=1 error_code=2"},
+		{"rescode=1", "rescode=1"},
+		{"a=1&access_token=t&refresh_token=r", "a=1&access_token=***&refresh_token=***"},
+		{`{"Authorization":"Bearer abc"}`, `{"Authorization":"Bearer ***"}`},
+	}
+	for _, c := range cases {
+		if got := redactString(c.in); got != c.want {
+			t.Errorf("redactString(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestAssignedLoggerIsRedacted(t *testing.T) {
+	rec := &recordingLogger{}
+	a := &Auth{Logger: rec}
+	a.log().Info("login", F("access_token", "t"), F("url", "/callback?code=abc"))
+	if len(rec.fields) != 2 {
+		t.Fatalf("fields = %v", rec.fields)
+	}
+	if rec.fields[0].Value != REDACTED {
+		t.Errorf("access_token = %v, want redacted", rec.fields[0].Value)
+	}
+	if rec.fields[1].Value != "/callback?code=***" {
+		t.Errorf("url = %v, want code redacted", rec.fields[1].Value)
+	}
+
+	api := &ApiAuth{Logger: rec}
+	api.log().Warn("request", F("client_secret", "s"))
+	if rec.fields[0].Value != REDACTED {
+		t.Errorf("ApiAuth client_secret = %v, want redacted", rec.fields[0].Value)
+	}
+}
@@ -0,0 +1,268 @@
+package filter
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"github.com/astaxie/beego/httplib"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 指标名称
+const (
+	METRIC_LOGINS           = "auth_logins_total"                 // 登录次数，label: result
+	METRIC_CALLBACK_FAILURE = "auth_callback_failures_total"      // sso回调失败次数，label: stage
+	METRIC_RESOURCE_RELOADS = "auth_resource_reloads_total"       // 用户权限重新加载次数，label: result
+	METRIC_DECISIONS        = "auth_decisions_total"              // 鉴权次数，label: rule、result
+	METRIC_SSO_DURATION     = "auth_sso_request_duration_seconds" // sso接口耗时，label: endpoint、status
+)
+
+// 指标收集接口，可以对接到已有的监控系统
+type Metrics interface {
+	IncCounter(name string, labels map[string]string)
+	Observe(name string, labels map[string]string, value float64)
+}
+
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // 与buckets一一对应，为累计值
+	count  uint64
+	sum    float64
+}
+
+// Metrics的内存实现，支持以Prometheus文本格式和expvar导出
+type MetricsRegistry struct {
+	mu         sync.Mutex
+	buckets    []float64
+	counters   map[string]map[string]float64    // 指标名 - label串 - 值
+	histograms map[string]map[string]*histogram // 指标名 - label串 - 直方图
+}
+
+var _ Metrics = (*MetricsRegistry)(nil)
+
+// buckets为直方图的上界，为空时使用默认值（单位秒）
+func NewMetricsRegistry(buckets ...float64) *MetricsRegistry {
+	if len(buckets) == 0 {
+		buckets = defaultBuckets
+	}
+	sort.Float64s(buckets)
+	return &MetricsRegistry{
+		buckets:    buckets,
+		counters:   make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*histogram),
+	}
+}
+
+// 将label按名称排序后格式化为 k1="v1",k2="v2"
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, strconv.Quote(labels[k])))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *MetricsRegistry) IncCounter(name string, labels map[string]string) {
+	key := formatLabels(labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	series, ok := r.counters[name]
+	if !ok {
+		series = make(map[string]float64)
+		r.counters[name] = series
+	}
+	series[key]++
+}
+
+func (r *MetricsRegistry) Observe(name string, labels map[string]string, value float64) {
+	key := formatLabels(labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	series, ok := r.histograms[name]
+	if !ok {
+		series = make(map[string]*histogram)
+		r.histograms[name] = series
+	}
+	h, ok := series[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(r.buckets))}
+		series[key] = h
+	}
+	for i, upper := range r.buckets {
+		if value <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 拼接label串，其中一个为空时不加逗号
+func joinLabels(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "," + b
+}
+
+// 以Prometheus文本格式输出全部指标
+func (r *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buf := &bytes.Buffer{}
+
+	names := make(map[string]bool)
+	for name := range r.counters {
+		names[name] = true
+	}
+	for _, name := range sortedKeys(names) {
+		fmt.Fprintf(buf, "# TYPE %s counter\n", name)
+		series := make(map[string]bool)
+		for k := range r.counters[name] {
+			series[k] = true
+		}
+		for _, k := range sortedKeys(series) {
+			fmt.Fprintf(buf, "%s{%s} %v\n", name, k, r.counters[name][k])
+		}
+	}
+
+	names = make(map[string]bool)
+	for name := range r.histograms {
+		names[name] = true
+	}
+	for _, name := range sortedKeys(names) {
+		fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+		series := make(map[string]bool)
+		for k := range r.histograms[name] {
+			series[k] = true
+		}
+		for _, k := range sortedKeys(series) {
+			h := r.histograms[name][k]
+			for i, upper := range r.buckets {
+				le := "le=" + strconv.Quote(strconv.FormatFloat(upper, 'g', -1, 64))
+				fmt.Fprintf(buf, "%s_bucket{%s} %d\n", name, joinLabels(k, le), h.counts[i])
+			}
+			fmt.Fprintf(buf, "%s_bucket{%s} %d\n", name, joinLabels(k, `le="+Inf"`), h.count)
+			fmt.Fprintf(buf, "%s_sum{%s} %v\n", name, k, h.sum)
+			fmt.Fprintf(buf, "%s_count{%s} %d\n", name, k, h.count)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// 以Prometheus文本格式提供指标的http handler，例如：
+//
+//	beego.Handler("/metrics", registry.Handler())
+func (r *MetricsRegistry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WritePrometheus(w)
+	})
+}
+
+// 当前全部指标的快照，counter为 label串 - 值，histogram为 label串 - {count, sum, buckets}
+func (r *MetricsRegistry) Snapshot() map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshot := make(map[string]interface{})
+	for name, series := range r.counters {
+		values := make(map[string]float64)
+		for k, v := range series {
+			values[k] = v
+		}
+		snapshot[name] = values
+	}
+	for name, series := range r.histograms {
+		values := make(map[string]interface{})
+		for k, h := range series {
+			buckets := make(map[string]uint64)
+			for i, upper := range r.buckets {
+				buckets[strconv.FormatFloat(upper, 'g', -1, 64)] = h.counts[i]
+			}
+			values[k] = map[string]interface{}{"count": h.count, "sum": h.sum, "buckets": buckets}
+		}
+		snapshot[name] = values
+	}
+	return snapshot
+}
+
+// 以expvar导出，可通过/debug/vars查看；同一名称只能发布一次
+func (r *MetricsRegistry) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return r.Snapshot()
+	}))
+}
+
+var numericSegment = regexp.MustCompile(`/[0-9][0-9,]*(/|$)`)
+
+// 将接口路径中的id替换为:id，避免label基数过大
+func ssoEndpoint(path string) string {
+	for numericSegment.MatchString(path) {
+		path = numericSegment.ReplaceAllString(path, "/:id$1")
+	}
+	return path
+}
+
+// 发送请求并记录sso接口耗时
+func sendRequest(metrics Metrics, logger Logger, req *httplib.BeegoHTTPRequest) (*http.Response, error) {
+	start := time.Now()
+	response, err := req.Response()
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(response.StatusCode)
+	}
+	// url解析失败时req.URL为nil，Response已返回错误
+	endpoint := "unknown"
+	if u := req.GetRequest().URL; u != nil {
+		endpoint = ssoEndpoint(u.Path)
+	}
+	elapsed := time.Since(start)
+	if metrics != nil {
+		labels := map[string]string{"endpoint": endpoint, "status": status}
+		metrics.Observe(METRIC_SSO_DURATION, labels, elapsed.Seconds())
+	}
+	logger.Debug("sso request", F("method", req.GetRequest().Method), F("endpoint", endpoint), F("status", status), F("elapsed", elapsed))
+	return response, err
+}
+
+func (a *Auth) incCounter(name string, labels map[string]string) {
+	if a.Metrics != nil {
+		a.Metrics.IncCounter(name, labels)
+	}
+}
+
+// 发送请求并将返回的json解析到v
+func (a *Auth) requestJSON(req *httplib.BeegoHTTPRequest, v interface{}) error {
+	if _, err := sendRequest(a.Metrics, a.log(), req); err != nil {
+		return err
+	}
+	return req.ToJSON(v)
+}
+
+// 发送请求
+func (a *ApiAuth) send(req *httplib.BeegoHTTPRequest) (*http.Response, error) {
+	return sendRequest(a.Metrics, a.log(), req)
+}
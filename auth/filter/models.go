@@ -5,21 +5,39 @@ import (
 	"errors"
 	"fmt"
 	"github.com/astaxie/beego/httplib"
-	"github.com/astaxie/beego/logs"
 	"github.com/tongwu13/golang_common/beego/controllers"
+	"strings"
 	"time"
 )
 
+// UrlControl中基于角色的规则前缀，其余规则均视为资源Data
+const (
+	RULE_PREFIX_ROLE      = "role:"     // role:ops 用户在ops角色或其子角色中
+	RULE_PREFIX_ROLE_TYPE = "roletype:" // roletype:admin 用户在任一角色中的类型为admin；roletype:admin@ops 限定在ops角色或其子角色中
+)
+
 type User struct {
 	// user
-	Id       string `json:"id"`
-	Fullname string `json:"fullname"`
-	Dn       string `json:"dn"`
+	Id       string            `json:"id"`
+	Fullname string            `json:"fullname"`
+	Dn       string            `json:"dn"`
+	Attrs    map[string]string `json:"attrs"` // 用户属性，用于ABAC条件
 
 	// resource
 	Resources   []*Resource          `json:"resource"`
-	ResourceMap map[string]*Resource `json:"resourceMap"`
+	ResourceMap map[string]*Resource `json:"resourceMap"` // 小写资源标识 - 资源，资源规则不区分大小写
 	CacheTime   int64                `json:"cacheTime"`
+	LoadTime    int64                `json:"loadTime,omitempty"` // 资源加载时间（unix纳秒），用于判断是否已被InvalidateUsers失效
+
+	// role
+	Roles     []*UserRole          `json:"roles"`
+	RoleMap   map[string]*UserRole `json:"roleMap"`   // 小写角色名 - 角色，包含所在角色的全部祖先角色
+	RoleTypes map[string]bool      `json:"roleTypes"` // 角色类型，格式为type或type@角色名（type在该角色或其子角色中）
+
+	// 模拟登录的管理员Id，为空表示本人登录，见impersonation.go
+	ImpersonatedBy string `json:"impersonatedBy,omitempty"`
+	// 通过api key认证时为key的Id，见apiKey.go
+	ApiKeyId string `json:"apiKeyId,omitempty"`
 
 	// token
 	Token Token `json:"-"`
@@ -29,6 +47,10 @@ type Resource struct {
 	Id          int64  `json:"id"`
 	Description string `json:"description"`
 	Data        string `json:"data"`
+
+	// Data为JSON时解析出的资源标识和属性，见abac.go
+	Key   string            `json:"key,omitempty"`
+	Attrs map[string]string `json:"attrs,omitempty"`
 }
 
 type Token struct {
@@ -43,16 +65,15 @@ type Token struct {
 
 func (u *User) Init(auth *Auth) error {
 	res := controllers.ResponseBody{}
-	if err := httplib.Get(fmt.Sprintf("%s/api/user", auth.Host)).
-		Header("Authorization", fmt.Sprintf("%s %s", u.Token.TokenType, u.Token.AccessToken)).
-		ToJSON(&res); err != nil {
+	if err := auth.requestJSON(httplib.Get(fmt.Sprintf("%s/api/user", auth.Host)).
+		Header("Authorization", fmt.Sprintf("%s %s", u.Token.TokenType, u.Token.AccessToken)), &res); err != nil {
 		return err
 	} else if res.ResCode != controllers.OK {
 		return errors.New(res.ResMsg)
 	} else {
 		userJson, _ := json.Marshal(res.Data)
 		if err := json.Unmarshal(userJson, u); err != nil {
-			logs.Error("res data error : %+v", res.Data)
+			auth.log().Error("res data error", F("data", res.Data))
 			return errors.New("res data error")
 		} else {
 			return nil
@@ -61,25 +82,128 @@ func (u *User) Init(auth *Auth) error {
 }
 
 func (u *User) LoadResource(auth *Auth) error {
+	if auth.ResourceProvider != nil {
+		resources, err := auth.ResourceProvider.ResourcesForUser(u.Id)
+		if err != nil {
+			return err
+		}
+		u.setResources(resources)
+		return nil
+	}
 	res := controllers.ResponseBody{}
-	if err := httplib.Get(fmt.Sprintf("%s/api/userResources", auth.Host)).
-		Header("Authorization", fmt.Sprintf("%s %s", u.Token.TokenType, u.Token.AccessToken)).
-		ToJSON(&res); err != nil {
+	if err := auth.requestJSON(httplib.Get(fmt.Sprintf("%s/api/userResources", auth.Host)).
+		Header("Authorization", fmt.Sprintf("%s %s", u.Token.TokenType, u.Token.AccessToken)), &res); err != nil {
 		return err
 	} else if res.ResCode != controllers.OK {
 		return errors.New(res.ResMsg)
 	} else {
 		resourcesJson, _ := json.Marshal(res.Data)
 		if err := json.Unmarshal(resourcesJson, &u.Resources); err != nil {
-			logs.Error("res data error : %+v", res.Data)
+			auth.log().Error("res data error", F("data", res.Data))
 			return errors.New("res data error")
 		} else {
-			u.ResourceMap = make(map[string]*Resource)
-			for _, v := range u.Resources {
-				u.ResourceMap[v.Data] = v
-			}
-			u.CacheTime = time.Now().Unix()
+			u.setResources(u.Resources)
 			return nil
 		}
 	}
 }
+
+func (u *User) setResources(resources []*Resource) {
+	u.Resources = nil
+	if resources != nil {
+		u.Resources = make([]*Resource, len(resources))
+	}
+	for i, v := range resources {
+		u.Resources[i] = v.parsed()
+	}
+	u.ResourceMap = resourceMap(u.Resources)
+	u.markLoaded()
+}
+
+// 以小写资源标识为key，与转为小写的UrlControl规则一致
+func resourceMap(resources []*Resource) map[string]*Resource {
+	m := make(map[string]*Resource, len(resources))
+	for _, r := range resources {
+		m[strings.ToLower(r.key())] = r
+	}
+	return m
+}
+
+// 记录资源加载时间
+func (u *User) markLoaded() {
+	now := time.Now()
+	u.CacheTime = now.Unix()
+	u.LoadTime = now.UnixNano()
+}
+
+func (u *User) LoadRoles(auth *Auth) error {
+	res := controllers.ResponseBody{}
+	if err := auth.requestJSON(httplib.Get(fmt.Sprintf("%s/api/userRoles?is_all=true", auth.Host)).
+		Header("Authorization", fmt.Sprintf("%s %s", u.Token.TokenType, u.Token.AccessToken)), &res); err != nil {
+		return err
+	} else if res.ResCode != controllers.OK {
+		return errors.New(res.ResMsg)
+	} else {
+		var roles []*UserRole
+		rolesJson, _ := json.Marshal(res.Data)
+		if err := json.Unmarshal(rolesJson, &roles); err != nil {
+			auth.log().Error("res data error", F("data", res.Data))
+			return errors.New("res data error")
+		}
+		u.setRoles(roles)
+		return nil
+	}
+}
+
+// 记录用户角色，沿ParentId向上展开，使子角色成员同时视为祖先角色成员
+func (u *User) setRoles(roles []*UserRole) {
+	u.Roles = roles
+	u.RoleMap = make(map[string]*UserRole)
+	u.RoleTypes = make(map[string]bool)
+	roleById := make(map[int]*UserRole)
+	for _, r := range roles {
+		roleById[r.Id] = r
+	}
+	for _, r := range roles {
+		roleType := strings.ToLower(r.RoleType)
+		if roleType != "" {
+			u.RoleTypes[roleType] = true
+		}
+		visited := make(map[int]bool)
+		for cur, ok := r, true; ok && !visited[cur.Id]; cur, ok = roleById[cur.ParentId] {
+			visited[cur.Id] = true
+			name := strings.ToLower(cur.Name)
+			if _, exists := u.RoleMap[name]; !exists {
+				u.RoleMap[name] = cur
+			}
+			if roleType != "" {
+				u.RoleTypes[roleType+"@"+name] = true
+			}
+		}
+	}
+}
+
+// 判断用户是否满足UrlControl中的单条规则，rule需已转为小写
+func (u *User) satisfies(rule string) bool {
+	switch {
+	case strings.HasPrefix(rule, RULE_PREFIX_ROLE):
+		_, ok := u.RoleMap[strings.TrimPrefix(rule, RULE_PREFIX_ROLE)]
+		return ok
+	case strings.HasPrefix(rule, RULE_PREFIX_ROLE_TYPE):
+		return u.RoleTypes[strings.TrimPrefix(rule, RULE_PREFIX_ROLE_TYPE)]
+	default:
+		_, ok := u.ResourceMap[rule]
+		return ok
+	}
+}
+
+// 用户不满足的规则
+func (u *User) missing(rules []string) []string {
+	var missing []string
+	for _, rule := range rules {
+		if !u.satisfies(rule) {
+			missing = append(missing, rule)
+		}
+	}
+	return missing
+}
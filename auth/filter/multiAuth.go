@@ -0,0 +1,248 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/tongwu13/golang_common/beego/i18n"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// 一个sso client及其匹配条件，配置了的条件需全部满足，未配置任何条件的client作为默认client
+type ClientRoute struct {
+	Hosts       []string // 请求Host（不含端口），任一匹配即可
+	PathPrefix  string   // 请求路径前缀，按路径段匹配（/app匹配/app和/app/x，不匹配/application）
+	Header      string   // 请求头名称
+	HeaderValue string   // 请求头的值
+	Config      *Config  // client配置，Name为空时使用ClientId作为名称
+}
+
+type clientRoute struct {
+	ClientRoute
+	auth *Auth
+}
+
+// 多client鉴权路由，同一个进程服务多个产品时按Host、路径前缀或请求头选择对应的client
+// 各client的session相互隔离，sso回调根据state中的client名称路由到发起登录的client
+type MultiAuth struct {
+	routes    []*clientRoute
+	byName    map[string]*Auth
+	callbacks map[string]string // client名称 - 回调路径（RedirectUri的路径）
+}
+
+var _ AuthService = (*MultiAuth)(nil)
+
+func NewMultiAuthService(routes []ClientRoute) AuthService {
+	m, err := newMultiAuth(routes, false)
+	if err != nil {
+		panic(fmt.Sprintf("multi auth service init failed: %v", err))
+	}
+	return m
+}
+
+// 校验全部client的配置并创建AuthService，client名称（默认为ClientId）重复或配置不合法时返回错误
+func NewMultiAuthServiceE(routes []ClientRoute) (AuthService, error) {
+	m, err := newMultiAuth(routes, true)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func newMultiAuth(routes []ClientRoute, strict bool) (*MultiAuth, error) {
+	if len(routes) == 0 {
+		return nil, errors.New("routes counld not be empty")
+	}
+	m := &MultiAuth{byName: make(map[string]*Auth), callbacks: make(map[string]string)}
+	// 未配置条件的默认client放在最后匹配，避免顺序在前时屏蔽其他client
+	var defaults []*clientRoute
+	for _, route := range routes {
+		if route.Config == nil {
+			return nil, errors.New("config counld not be nil")
+		}
+		config := *route.Config
+		if config.Name == "" {
+			config.Name = config.ClientId
+		}
+		if _, ok := m.byName[config.Name]; ok {
+			return nil, fmt.Errorf("duplicate client name %s", config.Name)
+		}
+		auth, err := newAuth(&config, strict)
+		if err != nil {
+			return nil, fmt.Errorf("client %s: %v", config.Name, err)
+		}
+		m.byName[auth.Name] = auth
+		m.callbacks[auth.Name] = callbackPath(auth.RedirectUri)
+		if route.isDefault() {
+			defaults = append(defaults, &clientRoute{ClientRoute: route, auth: auth})
+		} else {
+			m.routes = append(m.routes, &clientRoute{ClientRoute: route, auth: auth})
+		}
+	}
+	m.routes = append(m.routes, defaults...)
+	return m, nil
+}
+
+// RedirectUri的路径，解析失败时为空（不按state选择client）
+func callbackPath(redirectUri string) string {
+	u, err := url.Parse(redirectUri)
+	if err != nil {
+		return ""
+	}
+	if u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+// 未配置任何匹配条件
+func (r *ClientRoute) isDefault() bool {
+	return len(r.Hosts) == 0 && r.PathPrefix == "" && r.Header == ""
+}
+
+// 按名称获取client
+func (m *MultiAuth) Client(name string) (*Auth, bool) {
+	auth, ok := m.byName[name]
+	return auth, ok
+}
+
+// 选择处理当前请求的client，sso回调优先按state中的client名称选择，其次按配置顺序匹配有条件的client，最后使用默认client
+// state只在该client的回调路径上生效，其他请求不能通过code、state参数切换client
+func (m *MultiAuth) selectAuth(ctx *context.Context) *Auth {
+	path := ctx.Input.URL()
+	if ctx.Input.Query("code") != "" {
+		state := ctx.Input.Query("state")
+		if idx := strings.Index(state, STATE_SEPARATOR); idx > 0 {
+			name := state[:idx]
+			if auth, ok := m.byName[name]; ok && m.callbacks[name] == path {
+				return auth
+			}
+		}
+	}
+	host := ctx.Input.Host()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, route := range m.routes {
+		if route.matches(ctx, host, path) {
+			return route.auth
+		}
+	}
+	return nil
+}
+
+func (r *clientRoute) matches(ctx *context.Context, host, path string) bool {
+	if len(r.Hosts) > 0 {
+		matched := false
+		for _, h := range r.Hosts {
+			if strings.EqualFold(h, host) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if r.PathPrefix != "" && !hasPathPrefix(path, r.PathPrefix) {
+		return false
+	}
+	if r.Header != "" && ctx.Input.Header(r.Header) != r.HeaderValue {
+		return false
+	}
+	return true
+}
+
+// path等于prefix或位于prefix下的路径段中
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// 没有匹配的client时拒绝访问
+func (m *MultiAuth) reject(ctx *context.Context) {
+	if ctx.Input.Header("x-requested-with") == "XMLHttpRequest" {
+		ctx.ResponseWriter.WriteHeader(403)
+		ctx.WriteString(i18n.T(ctx, i18n.MSG_CLIENT_NOT_FOUND))
+	} else {
+		beego.Exception(403, ctx)
+	}
+}
+
+func (m *MultiAuth) CheckLoginFilter(ctx *context.Context) {
+	if auth := m.selectAuth(ctx); auth != nil {
+		auth.CheckLoginFilter(ctx)
+	} else {
+		m.reject(ctx)
+	}
+}
+
+func (m *MultiAuth) CheckAuthorityFilter(ctx *context.Context, routerPattern string) {
+	if auth := m.selectAuth(ctx); auth != nil {
+		auth.CheckAuthorityFilter(ctx, routerPattern)
+	} else {
+		m.reject(ctx)
+	}
+}
+
+func (m *MultiAuth) Login(code string, ctx *context.Context) {
+	if auth := m.selectAuth(ctx); auth != nil {
+		auth.Login(code, ctx)
+	} else {
+		m.reject(ctx)
+	}
+}
+
+func (m *MultiAuth) RedirectToLogin(ctx *context.Context) {
+	if auth := m.selectAuth(ctx); auth != nil {
+		auth.RedirectToLogin(ctx)
+	} else {
+		m.reject(ctx)
+	}
+}
+
+func (m *MultiAuth) Logout(ctx *context.Context, state string) {
+	if auth := m.selectAuth(ctx); auth != nil {
+		auth.Logout(ctx, state)
+	} else {
+		m.reject(ctx)
+	}
+}
+
+func (m *MultiAuth) CurrentUser(ctx *context.Context) User {
+	if auth := m.selectAuth(ctx); auth != nil {
+		return auth.CurrentUser(ctx)
+	}
+	return anonymousUser()
+}
+
+func (m *MultiAuth) StartImpersonation(ctx *context.Context, userId string) error {
+	if auth := m.selectAuth(ctx); auth != nil {
+		return auth.StartImpersonation(ctx, userId)
+	}
+	return ErrImpersonationDisabled
+}
+
+func (m *MultiAuth) StopImpersonation(ctx *context.Context) error {
+	if auth := m.selectAuth(ctx); auth != nil {
+		return auth.StopImpersonation(ctx)
+	}
+	return ErrNotImpersonating
+}
+
+// 使全部client中指定用户的资源缓存失效，各client的webhook可通过Client(name)获取
+func (m *MultiAuth) InvalidateUsers(userIds ...string) {
+	for _, auth := range m.byName {
+		auth.InvalidateUsers(userIds...)
+	}
+}
+
+// 为全部client追加UrlControl规则
+func (m *MultiAuth) AddUrlControl(key string, rules ...string) {
+	for _, auth := range m.byName {
+		auth.AddUrlControl(key, rules...)
+	}
+}
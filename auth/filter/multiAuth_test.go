@@ -0,0 +1,81 @@
+package filter
+
+import (
+	"github.com/astaxie/beego/context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testClientConfig(name, clientId, redirectUri string) *Config {
+	return &Config{
+		Name:         name,
+		ClientId:     clientId,
+		ClientSecret: "secret",
+		RedirectUri:  redirectUri,
+		Host:         "http://sso.example.com",
+	}
+}
+
+func newRequestContext(target string) *context.Context {
+	ctx := context.NewContext()
+	ctx.Reset(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	return ctx
+}
+
+func TestMultiAuthSelect(t *testing.T) {
+	service, err := NewMultiAuthServiceE([]ClientRoute{
+		{PathPrefix: "/app", Config: testClientConfig("app", "1", "http://example.com/app/callback")},
+		{PathPrefix: "/admin/", Config: testClientConfig("admin", "2", "http://example.com/admin/callback")},
+		{Config: testClientConfig("default", "3", "http://example.com")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := service.(*MultiAuth)
+	cases := []struct {
+		target string
+		want   string
+	}{
+		{"/app", "app"},
+		{"/app/orders", "app"},
+		{"/application", "default"},
+		{"/admin", "admin"},
+		{"/admin/users", "admin"},
+		{"/administrator", "default"},
+		{"/app/callback?code=c&state=admin|/", "app"},
+		{"/admin/callback?code=c&state=admin|/", "admin"},
+		{"/app/callback?code=c&state=default|/", "app"},
+		{"/app/orders?code=c&state=default|/", "app"},
+		{"/?code=c&state=default|/", "default"},
+	}
+	for _, c := range cases {
+		got := ""
+		if auth := m.selectAuth(newRequestContext(c.target)); auth != nil {
+			got = auth.Name
+		}
+		if got != c.want {
+			t.Errorf("%s: selected %q, want %q", c.target, got, c.want)
+		}
+	}
+}
+
+func TestNewMultiAuthServiceE(t *testing.T) {
+	cases := []struct {
+		name   string
+		routes []ClientRoute
+	}{
+		{"empty", nil},
+		{"nil config", []ClientRoute{{}}},
+		{"duplicate client id", []ClientRoute{
+			{PathPrefix: "/a", Config: testClientConfig("", "1", "http://example.com/a")},
+			{PathPrefix: "/b", Config: testClientConfig("", "1", "http://example.com/b")},
+		}},
+		{"invalid config", []ClientRoute{{Config: testClientConfig("", "x", "http://example.com")}}},
+	}
+	for _, c := range cases {
+		if _, err := NewMultiAuthServiceE(c.routes); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package filter
+
+import (
+	"errors"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"strings"
+)
+
+var ErrNotLogin = errors.New("user is not logged in")
+
+// 当前用户缺少所需权限
+type PermissionError struct {
+	UserId  string
+	Missing []string // 用户不满足的规则
+}
+
+func (e *PermissionError) Error() string {
+	return "user " + e.UserId + " lacks " + strings.Join(e.Missing, ",")
+}
+
+func init() {
+	// 模板中使用：{{if hasPerm .User "order:edit"}}...{{end}}
+	beego.AddFuncMap("hasPerm", func(user interface{}, resource string) bool {
+		u, ok := asUser(user)
+		return ok && u.Has(resource)
+	})
+	beego.AddFuncMap("hasAnyPerm", func(user interface{}, resources ...string) bool {
+		u, ok := asUser(user)
+		return ok && u.HasAny(resources...)
+	})
+	beego.AddFuncMap("hasAllPerm", func(user interface{}, resources ...string) bool {
+		u, ok := asUser(user)
+		return ok && u.HasAll(resources...)
+	})
+	beego.AddFuncMap("hasRole", func(user interface{}, role string) bool {
+		u, ok := asUser(user)
+		return ok && u.HasRole(role)
+	})
+	beego.AddFuncMap("hasAnyRole", func(user interface{}, roles ...string) bool {
+		u, ok := asUser(user)
+		return ok && u.HasAnyRole(roles...)
+	})
+}
+
+func asUser(v interface{}) (*User, bool) {
+	switch u := v.(type) {
+	case User:
+		return &u, true
+	case *User:
+		return u, u != nil
+	default:
+		return nil, false
+	}
+}
+
+// 用户是否拥有资源（按资源标识匹配，不区分大小写）
+func (u *User) Has(resource string) bool {
+	_, ok := u.ResourceMap[strings.ToLower(resource)]
+	return ok
+}
+
+// 用户是否拥有任一资源
+func (u *User) HasAny(resources ...string) bool {
+	for _, r := range resources {
+		if u.Has(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// 用户是否拥有全部资源
+func (u *User) HasAll(resources ...string) bool {
+	for _, r := range resources {
+		if !u.Has(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// 用户是否在角色或其子角色中，角色名不区分大小写
+func (u *User) HasRole(role string) bool {
+	_, ok := u.RoleMap[strings.ToLower(role)]
+	return ok
+}
+
+// 用户是否在任一角色中
+func (u *User) HasAnyRole(roles ...string) bool {
+	for _, r := range roles {
+		if u.HasRole(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// 用户是否在全部角色中
+func (u *User) HasAllRoles(roles ...string) bool {
+	for _, r := range roles {
+		if !u.HasRole(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// 用户是否拥有角色类型，格式同UrlControl中的roletype规则：type或type@角色名
+func (u *User) HasRoleType(roleType string) bool {
+	return u.RoleTypes[strings.ToLower(roleType)]
+}
+
+// 校验当前用户是否满足全部规则，规则语法与UrlControl一致（资源Data、role:xxx、roletype:xxx，不区分大小写）
+// 未登录返回ErrNotLogin，缺少权限返回*PermissionError
+func Require(ctx *context.Context, rules ...string) error {
+	user, ok := userFromContext(ctx)
+	if !ok {
+		return ErrNotLogin
+	}
+	return requireRules(user, rules)
+}
+
+// 同Require，从a的用户存储中读取用户，适用于未经过a的过滤器的请求
+func (a *Auth) Require(ctx *context.Context, rules ...string) error {
+	user, ok := a.contextUser(ctx)
+	if !ok {
+		return ErrNotLogin
+	}
+	return requireRules(user, rules)
+}
+
+// 按请求选择client后校验
+func (m *MultiAuth) Require(ctx *context.Context, rules ...string) error {
+	if auth := m.selectAuth(ctx); auth != nil {
+		return auth.Require(ctx, rules...)
+	}
+	return ErrNotLogin
+}
+
+func requireRules(user User, rules []string) error {
+	lower := make([]string, len(rules))
+	for i, rule := range rules {
+		lower[i] = strings.ToLower(strings.TrimSpace(rule))
+	}
+	if missing := user.missing(lower); len(missing) > 0 {
+		return &PermissionError{UserId: user.Id, Missing: missing}
+	}
+	return nil
+}
+
+// 当前请求的用户，优先使用过滤器写入请求上下文的用户，其次为处理本次请求的Auth的用户存储
+func userFromContext(ctx *context.Context) (User, bool) {
+	if user, ok := ctx.Input.GetData(DATA_KEY_USER).(User); ok {
+		return user, true
+	}
+	if a, ok := ctx.Input.GetData(dataKeyAuth).(*Auth); ok {
+		return a.users().Get(ctx, a.sessionKey())
+	}
+	return sessionUserStore{}.Get(ctx, SESSION_KEY_USER)
+}
+
+// a的过滤器写入请求上下文的用户，没有时从a的用户存储中读取
+func (a *Auth) contextUser(ctx *context.Context) (User, bool) {
+	if user, ok := ctx.Input.GetData(DATA_KEY_USER).(User); ok {
+		if owner, _ := ctx.Input.GetData(dataKeyAuth).(*Auth); owner == nil || owner == a {
+			return user, true
+		}
+	}
+	return a.users().Get(ctx, a.sessionKey())
+}
@@ -0,0 +1,29 @@
+package filter
+
+import "testing"
+
+func TestResourceRulesIgnoreCase(t *testing.T) {
+	user := User{Id: "tom"}
+	user.setResources([]*Resource{
+		{Data: "Order:Edit"},
+		{Data: `{"key":"Report:View","attrs":{"tenant":"t1"}}`},
+	})
+	cases := []struct {
+		rule string
+		want bool
+	}{
+		{"Order:Edit", true},
+		{"order:edit", true},
+		{"ORDER:EDIT", true},
+		{"report:view", true},
+		{"order:delete", false},
+	}
+	for _, c := range cases {
+		if got := user.Has(c.rule); got != c.want {
+			t.Errorf("Has(%q) = %v, want %v", c.rule, got, c.want)
+		}
+		if err := requireRules(user, []string{c.rule}); (err == nil) != c.want {
+			t.Errorf("requireRules(%q) = %v, want allowed %v", c.rule, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package filter
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrNoSnapshot = errors.New("policy snapshot has not been loaded")
+
+// 按用户Id提供资源列表，配置后登录和鉴权不再逐个用户请求sso
+type ResourceProvider interface {
+	ResourcesForUser(userId string) ([]*Resource, error)
+}
+
+// 某一时刻Client下完整的权限数据
+type PolicySnapshot struct {
+	Roles     []*Role             // 全部角色（含ParentId）
+	RoleUsers map[int][]*RoleUser // 角色id - 角色中的用户
+	Relations []*RelatedInfo      // 角色资源关联
+	Resources []*ApiResource      // 全部资源
+	LoadedAt  time.Time           // 快照拉取时间
+
+	userResources map[string][]*Resource // 用户Id - 有效资源（含父角色继承）
+}
+
+// 计算每个用户的有效资源：用户所在角色及其全部祖先角色关联的资源
+func (s *PolicySnapshot) compute() {
+	roleMap := make(map[int]*Role)
+	for _, r := range s.Roles {
+		roleMap[r.Id] = r
+	}
+	resourceMap := make(map[int]*ApiResource)
+	for _, r := range s.Resources {
+		resourceMap[r.Id] = r
+	}
+	roleResources := make(map[int][]int)
+	for _, r := range s.Relations {
+		roleResources[r.RoleId] = append(roleResources[r.RoleId], r.ResourceId)
+	}
+
+	// 角色id - 有效资源id（自身及祖先角色）
+	effective := make(map[int]map[int]bool)
+	for _, role := range s.Roles {
+		ids := make(map[int]bool)
+		visited := make(map[int]bool)
+		for cur, ok := role, true; ok && !visited[cur.Id]; cur, ok = roleMap[cur.ParentId] {
+			visited[cur.Id] = true
+			for _, id := range roleResources[cur.Id] {
+				ids[id] = true
+			}
+		}
+		effective[role.Id] = ids
+	}
+
+	userResourceIds := make(map[string]map[int]bool)
+	for roleId, users := range s.RoleUsers {
+		for _, u := range users {
+			ids, ok := userResourceIds[u.UserId]
+			if !ok {
+				ids = make(map[int]bool)
+				userResourceIds[u.UserId] = ids
+			}
+			for id := range effective[roleId] {
+				ids[id] = true
+			}
+		}
+	}
+
+	s.userResources = make(map[string][]*Resource)
+	for userId, ids := range userResourceIds {
+		resources := make([]*Resource, 0, len(ids))
+		for id := range ids {
+			if r, ok := resourceMap[id]; ok {
+				resources = append(resources, &Resource{Id: int64(r.Id), Description: r.Description, Data: r.Data})
+			}
+		}
+		s.userResources[userId] = resources
+	}
+}
+
+var _ ResourceProvider = (*PolicyPoint)(nil)
+
+// 本地权限决策点
+// 定期通过ApiAuthService拉取完整权限快照并在本地计算用户资源，sso不可用时继续使用最近一次成功拉取的快照
+type PolicyPoint struct {
+	api      ApiAuthService
+	interval time.Duration
+
+	mu       sync.RWMutex
+	snapshot *PolicySnapshot
+	stop     chan struct{}
+}
+
+func NewPolicyPoint(api ApiAuthService, interval time.Duration) *PolicyPoint {
+	if api == nil {
+		panic("policy point init failed: api service counld not be nil")
+	}
+	return &PolicyPoint{api: api, interval: interval}
+}
+
+// 立即拉取一次快照，失败时保留原快照；角色取自GetAllRole的平铺列表，层级由ParentId计算
+func (p *PolicyPoint) Refresh() error {
+	snapshot := &PolicySnapshot{RoleUsers: make(map[int][]*RoleUser)}
+	var err error
+	if snapshot.Roles, err = p.api.GetAllRole(false, false); err != nil {
+		return err
+	}
+	for _, role := range snapshot.Roles {
+		if snapshot.RoleUsers[role.Id], err = p.api.GetUsersOfRole(role.Id); err != nil {
+			return err
+		}
+	}
+	if snapshot.Relations, err = p.api.GetAllRelatedInfo(); err != nil {
+		return err
+	}
+	if snapshot.Resources, err = p.api.GetAllResources(); err != nil {
+		return err
+	}
+	snapshot.LoadedAt = time.Now()
+	snapshot.compute()
+
+	p.mu.Lock()
+	p.snapshot = snapshot
+	p.mu.Unlock()
+	return nil
+}
+
+// 拉取首个快照并开始定期刷新，首次拉取失败时仍会继续定期重试
+func (p *PolicyPoint) Start() error {
+	err := p.Refresh()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil || p.interval <= 0 {
+		return err
+	}
+	p.stop = make(chan struct{})
+	go p.loop(p.stop)
+	return err
+}
+
+// 停止定期刷新
+func (p *PolicyPoint) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+func (p *PolicyPoint) loop(stop chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Refresh(); err != nil {
+				defaultLogger.Error("policy snapshot refresh failed", F("error", err))
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// 当前快照的副本，未拉取成功过时返回nil；快照为多个请求共享，调用方可以修改副本
+func (p *PolicyPoint) Snapshot() *PolicySnapshot {
+	snapshot := p.current()
+	if snapshot == nil {
+		return nil
+	}
+	return cloneValue(snapshot).(*PolicySnapshot)
+}
+
+func (p *PolicyPoint) current() *PolicySnapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.snapshot
+}
+
+// 用户的有效资源，不在任何角色中的用户返回空列表
+func (p *PolicyPoint) ResourcesForUser(userId string) ([]*Resource, error) {
+	snapshot := p.current()
+	if snapshot == nil {
+		return nil, ErrNoSnapshot
+	}
+	return copyResources(snapshot.userResources[userId]), nil
+}
+
+// 复制资源列表，快照和缓存中的资源为多个请求共享，不能交给调用方修改
+func copyResources(resources []*Resource) []*Resource {
+	if resources == nil {
+		return nil
+	}
+	copied := make([]*Resource, len(resources))
+	for i, r := range resources {
+		c := *r
+		if r.Attrs != nil {
+			c.Attrs = make(map[string]string, len(r.Attrs))
+			for k, v := range r.Attrs {
+				c.Attrs[k] = v
+			}
+		}
+		copied[i] = &c
+	}
+	return copied
+}
@@ -0,0 +1,60 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 返回固定权限数据的api
+type fixedPolicyApi struct {
+	ApiAuthService
+}
+
+func (fixedPolicyApi) GetAllRole(relatedResource, relatedUser bool) ([]*Role, error) {
+	return []*Role{{Id: 1, Name: "root"}, {Id: 2, Name: "ops", ParentId: 1}}, nil
+}
+
+func (fixedPolicyApi) GetUsersOfRole(roleId int) ([]*RoleUser, error) {
+	if roleId == 2 {
+		return []*RoleUser{{RoleId: 2, UserId: "tom"}}, nil
+	}
+	return nil, nil
+}
+
+func (fixedPolicyApi) GetAllRelatedInfo() ([]*RelatedInfo, error) {
+	return []*RelatedInfo{{RoleId: 1, ResourceId: 10}}, nil
+}
+
+func (fixedPolicyApi) GetAllResources() ([]*ApiResource, error) {
+	return []*ApiResource{{Id: 10, Data: "order:view"}}, nil
+}
+
+func TestPolicySnapshotIsCopy(t *testing.T) {
+	p := NewPolicyPoint(fixedPolicyApi{}, 0)
+	if p.Snapshot() != nil {
+		t.Fatal("Snapshot before Refresh should be nil")
+	}
+	if err := p.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	want := p.Snapshot()
+
+	got := p.Snapshot()
+	got.Roles[0].Name = "changed"
+	got.Roles = append(got.Roles, &Role{Id: 3})
+	got.RoleUsers[2][0].UserId = "jerry"
+	got.RoleUsers[3] = []*RoleUser{{RoleId: 3, UserId: "jerry"}}
+	got.Relations[0].ResourceId = 11
+	got.Resources[0].Data = "order:edit"
+
+	if again := p.Snapshot(); !reflect.DeepEqual(again, want) {
+		t.Errorf("Snapshot changed by caller: %+v, want %+v", again, want)
+	}
+	resources, err := p.ResourcesForUser("tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 || resources[0].Data != "order:view" {
+		t.Errorf("ResourcesForUser = %+v, want order:view", resources)
+	}
+}
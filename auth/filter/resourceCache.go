@@ -0,0 +1,223 @@
+package filter
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const DEFAULT_RESOURCE_CACHE_SIZE = 10000
+
+// 进程内按用户Id共享的资源缓存
+//
+// 同一用户的并发加载合并为一次请求；缓存存活超过refreshAfter后仍返回缓存，同时在后台刷新；
+// 超过ttl后同步加载；缓存数量超出capacity时淘汰最久未使用的用户。
+// 缓存中的资源及其ResourceMap为只读，多个session共享同一份数据。
+//
+// 未命中时同步加载，同一用户的请求只等待一次加载。Purge后所有在线用户的下一次请求都会向sso加载，
+// 短时间内的请求量约为在线用户数；这是为了让撤销的权限立即生效，不回退到session中的旧资源。
+type ResourceCache struct {
+	mu           sync.Mutex
+	ttl          time.Duration
+	refreshAfter time.Duration
+	capacity     int
+	entries      map[string]*list.Element
+	lru          *list.List // 最近使用的在前
+	group        callGroup
+	stats        ResourceCacheStats
+	now          func() time.Time
+
+	// 失效前发起的加载结果不再写入缓存：每次失效递增seq，加载开始时记录seq，
+	// 结束时若该用户（或全部缓存）在此之后被失效则丢弃结果，其他用户的加载不受影响
+	seq         uint64
+	purgedAt    uint64
+	invalidated map[string]uint64 // 用户 - 最近一次失效的seq，没有进行中的加载时清空
+	loading     int
+}
+
+type resourceCacheEntry struct {
+	userId      string
+	resources   []*Resource
+	resourceMap map[string]*Resource // 写入时建立，避免每次请求重建
+	loadedAt    time.Time
+	refreshing  bool
+}
+
+type ResourceCacheStats struct {
+	Hits      int64
+	Misses    int64
+	Refreshes int64 // 后台刷新次数
+	Evictions int64
+	Size      int
+}
+
+// ttl为缓存有效期，capacity<=0时使用默认值；到期前最后1/5的时间内命中会触发后台刷新
+func NewResourceCache(ttl time.Duration, capacity int) *ResourceCache {
+	if capacity <= 0 {
+		capacity = DEFAULT_RESOURCE_CACHE_SIZE
+	}
+	return &ResourceCache{
+		ttl:          ttl,
+		refreshAfter: ttl * 4 / 5,
+		capacity:     capacity,
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+		now:          time.Now,
+		invalidated:  make(map[string]uint64),
+	}
+}
+
+// 设置开始后台刷新的时间，需小于ttl
+func (c *ResourceCache) SetRefreshAfter(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d > 0 && d < c.ttl {
+		c.refreshAfter = d
+	}
+}
+
+// 获取用户资源，未命中或已过期时调用load加载
+func (c *ResourceCache) Get(userId string, load func() ([]*Resource, error)) ([]*Resource, error) {
+	entry, err := c.get(userId, load)
+	if err != nil {
+		return nil, err
+	}
+	return entry.resources, nil
+}
+
+func (c *ResourceCache) get(userId string, load func() ([]*Resource, error)) (*resourceCacheEntry, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[userId]; ok {
+		entry := el.Value.(*resourceCacheEntry)
+		age := c.now().Sub(entry.loadedAt)
+		if age < c.ttl {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			if age >= c.refreshAfter && !entry.refreshing {
+				entry.refreshing = true
+				c.stats.Refreshes++
+				go c.refresh(userId, load)
+			}
+			c.mu.Unlock()
+			return entry, nil
+		}
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+	return c.load(userId, load)
+}
+
+func (c *ResourceCache) load(userId string, load func() ([]*Resource, error)) (*resourceCacheEntry, error) {
+	v, err, _ := c.group.do(userId, func() (interface{}, error) {
+		c.mu.Lock()
+		start := c.seq
+		c.loading++
+		c.mu.Unlock()
+		var entry *resourceCacheEntry
+		defer func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.loading--
+			if entry != nil && c.purgedAt <= start && c.invalidated[userId] <= start {
+				c.put(entry)
+			}
+			if c.loading == 0 && len(c.invalidated) > 0 {
+				c.invalidated = make(map[string]uint64)
+			}
+		}()
+		resources, err := load()
+		if err != nil {
+			return nil, err
+		}
+		entry = &resourceCacheEntry{userId: userId, resources: resources, resourceMap: resourceMap(resources)}
+		return entry, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*resourceCacheEntry), nil
+}
+
+func (c *ResourceCache) refresh(userId string, load func() ([]*Resource, error)) {
+	if _, err := c.load(userId, load); err != nil {
+		defaultLogger.Warn("refresh resource cache failed", F("user_id", userId), F("error", err))
+		c.mu.Lock()
+		if el, ok := c.entries[userId]; ok {
+			el.Value.(*resourceCacheEntry).refreshing = false
+		}
+		c.mu.Unlock()
+	}
+}
+
+// 写入缓存，调用时需持有mu
+func (c *ResourceCache) put(entry *resourceCacheEntry) {
+	entry.loadedAt = c.now()
+	if el, ok := c.entries[entry.userId]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[entry.userId] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*resourceCacheEntry).userId)
+		c.stats.Evictions++
+	}
+}
+
+// 删除用户的缓存，下次访问时重新加载
+func (c *ResourceCache) Invalidate(userId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	if c.loading > 0 {
+		c.invalidated[userId] = c.seq
+	}
+	if el, ok := c.entries[userId]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, userId)
+	}
+}
+
+// 清空全部缓存
+func (c *ResourceCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	c.purgedAt = c.seq
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+func (c *ResourceCache) Stats() ResourceCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+// 从共享缓存中获取用户资源；缓存中的资源和ResourceMap在写入时已建立，直接共享
+func (a *Auth) loadCachedResources(user *User) error {
+	token, id := user.Token, user.Id
+	entry, err := a.ResourceCache.get(id, func() ([]*Resource, error) {
+		loaded := User{Id: id, Token: token}
+		if err := loaded.LoadResource(a); err != nil {
+			return nil, err
+		}
+		return loaded.Resources, nil
+	})
+	if err != nil {
+		return err
+	}
+	user.Resources = entry.resources
+	user.ResourceMap = entry.resourceMap
+	user.markLoaded()
+	return nil
+}
+
+// 是否使用共享缓存加载该用户的资源，模拟登录和api key的用户不使用
+func (a *Auth) useResourceCache(user *User) bool {
+	return a.ResourceCache != nil && a.AutoLoadResource && user.ImpersonatedBy == "" && user.ApiKeyId == ""
+}
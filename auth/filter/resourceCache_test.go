@@ -0,0 +1,42 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadCachedResourcesSharesMap(t *testing.T) {
+	loads := 0
+	cache := NewResourceCache(time.Minute, 0)
+	load := func() ([]*Resource, error) {
+		loads++
+		return []*Resource{(&Resource{Data: "Order:Edit"}).parsed()}, nil
+	}
+	var maps []uintptr
+	for i := 0; i < 3; i++ {
+		entry, err := cache.get("tom", load)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := entry.resourceMap["order:edit"]; !ok {
+			t.Fatalf("resourceMap = %v, want lower case key", entry.resourceMap)
+		}
+		maps = append(maps, reflect.ValueOf(entry.resourceMap).Pointer())
+	}
+	if loads != 1 {
+		t.Errorf("loads = %d, want 1", loads)
+	}
+	if maps[0] != maps[1] || maps[1] != maps[2] {
+		t.Error("resourceMap should be built once per load and shared by hits")
+	}
+
+	cache.Purge()
+	entry, err := cache.get("tom", load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 2 || reflect.ValueOf(entry.resourceMap).Pointer() == maps[0] {
+		t.Errorf("after Purge: loads = %d, want a fresh load and map", loads)
+	}
+}
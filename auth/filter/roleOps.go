@@ -0,0 +1,293 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrRoleCycle = errors.New("role hierarchy would contain a cycle")
+
+// 角色不存在
+type RoleNotFoundError struct {
+	RoleId int
+}
+
+func (e *RoleNotFoundError) Error() string {
+	return fmt.Sprintf("role %d not found", e.RoleId)
+}
+
+// 组合操作中执行的一次ApiAuthService调用
+type OpCall struct {
+	Method string        `json:"method"`
+	Args   []interface{} `json:"args"`
+	Result interface{}   `json:"result,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+func (c *OpCall) String() string {
+	args := make([]string, 0, len(c.Args))
+	for _, a := range c.Args {
+		args = append(args, fmt.Sprintf("%v", a))
+	}
+	s := fmt.Sprintf("%s(%s)", c.Method, strings.Join(args, ", "))
+	if c.Error != "" {
+		return s + " failed: " + c.Error
+	}
+	return s
+}
+
+// 组合操作的执行记录，出错时包含出错前已执行的调用
+type OpReport struct {
+	Op    string    `json:"op"`
+	Calls []*OpCall `json:"calls"`
+}
+
+func (r *OpReport) record(method string, result interface{}, err error, args ...interface{}) {
+	call := &OpCall{Method: method, Args: args, Result: result}
+	if err != nil {
+		call.Error = err.Error()
+	}
+	r.Calls = append(r.Calls, call)
+}
+
+func (r *OpReport) String() string {
+	lines := make([]string, 0, len(r.Calls)+1)
+	lines = append(lines, r.Op)
+	for _, c := range r.Calls {
+		lines = append(lines, "  "+c.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 基于ApiAuthService的角色组合操作：移动子树、复制角色、合并角色
+type RoleOps struct {
+	api ApiAuthService
+}
+
+func NewRoleOps(api ApiAuthService) *RoleOps {
+	return &RoleOps{api: api}
+}
+
+func (o *RoleOps) forest() (RoleForest, error) {
+	roles, err := o.api.GetAllRole(false, false)
+	if err != nil {
+		return nil, err
+	}
+	forest, _ := BuildRoleTree(roles)
+	return forest, nil
+}
+
+// 校验newParentId存在（0为根）且不是roleId自身或其后代
+func checkReparent(forest RoleForest, roleId, newParentId int) (*RoleTree, error) {
+	node := forest.Find(roleId)
+	if node == nil {
+		return nil, &RoleNotFoundError{RoleId: roleId}
+	}
+	if newParentId == 0 {
+		return node, nil
+	}
+	if forest.Find(newParentId) == nil {
+		return nil, &RoleNotFoundError{RoleId: newParentId}
+	}
+	if newParentId == roleId {
+		return nil, ErrRoleCycle
+	}
+	for _, d := range forest.Descendants(roleId) {
+		if d.Id == newParentId {
+			return nil, ErrRoleCycle
+		}
+	}
+	return node, nil
+}
+
+// 将角色及其子树移动到newParentId下，newParentId为0时成为根角色
+func (o *RoleOps) MoveRole(roleId, newParentId int) (*OpReport, error) {
+	report := &OpReport{Op: fmt.Sprintf("move role %d to %d", roleId, newParentId)}
+	forest, err := o.forest()
+	if err != nil {
+		return report, err
+	}
+	node, err := checkReparent(forest, roleId, newParentId)
+	if err != nil {
+		return report, err
+	}
+	if node.ParentId == newParentId {
+		return report, nil
+	}
+	_, err = o.api.UpdateRole(roleId, node.Name, node.Description, newParentId)
+	report.record("UpdateRole", nil, err, roleId, node.Name, node.Description, newParentId)
+	return report, err
+}
+
+// 将角色及其子树（含资源关联，withMembers为true时含成员）复制到newParentId下
+// name为新根角色的名称，为空时沿用原名称；子角色沿用原名称。返回新根角色的id
+func (o *RoleOps) CloneRole(roleId, newParentId int, name string, withMembers bool) (int, *OpReport, error) {
+	report := &OpReport{Op: fmt.Sprintf("clone role %d to %d", roleId, newParentId)}
+	forest, err := o.forest()
+	if err != nil {
+		return 0, report, err
+	}
+	node := forest.Find(roleId)
+	if node == nil {
+		return 0, report, &RoleNotFoundError{RoleId: roleId}
+	}
+	if newParentId != 0 && forest.Find(newParentId) == nil {
+		return 0, report, &RoleNotFoundError{RoleId: newParentId}
+	}
+	if name == "" {
+		name = node.Name
+	}
+	newId, err := o.cloneNode(node, newParentId, name, withMembers, report)
+	return newId, report, err
+}
+
+func (o *RoleOps) cloneNode(node *RoleTree, parentId int, name string, withMembers bool, report *OpReport) (int, error) {
+	newId, err := o.api.AddRole(name, node.Description, parentId)
+	report.record("AddRole", newId, err, name, node.Description, parentId)
+	if err != nil {
+		return 0, err
+	}
+	related, err := o.api.GetRelatedInfo(node.Id)
+	report.record("GetRelatedInfo", len(related), err, node.Id)
+	if err != nil {
+		return newId, err
+	}
+	if resIds := relatedResourceIds(related); len(resIds) > 0 {
+		n, err := o.api.AddRelations(newId, resIds)
+		report.record("AddRelations", n, err, newId, resIds)
+		if err != nil {
+			return newId, err
+		}
+	}
+	if withMembers {
+		if err := o.copyMembers(node.Id, newId, nil, report); err != nil {
+			return newId, err
+		}
+	}
+	for _, child := range node.Children {
+		if _, err := o.cloneNode(child, newId, child.Name, withMembers, report); err != nil {
+			return newId, err
+		}
+	}
+	return newId, nil
+}
+
+// 将fromId的成员加入toId，跳过exists中已有的用户
+func (o *RoleOps) copyMembers(fromId, toId int, exists map[string]bool, report *OpReport) error {
+	users, err := o.api.GetUsersOfRole(fromId)
+	report.record("GetUsersOfRole", len(users), err, fromId)
+	if err != nil {
+		return err
+	}
+	infos := make([]UserInfo, 0, len(users))
+	for _, u := range users {
+		if !exists[u.UserId] {
+			infos = append(infos, UserInfo{UserId: u.UserId, RoleType: u.RoleType})
+		}
+	}
+	if len(infos) == 0 {
+		return nil
+	}
+	n, err := o.api.AddUserToRole(toId, infos)
+	report.record("AddUserToRole", n, err, toId, infos)
+	return err
+}
+
+func relatedResourceIds(related []*RelatedInfo) []int {
+	ids := make([]int, 0, len(related))
+	for _, r := range related {
+		ids = append(ids, r.ResourceId)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// 将sourceId的成员和资源关联合并到targetId；deleteSource为true时将source的子角色移到target下并删除source
+func (o *RoleOps) MergeRoles(sourceId, targetId int, deleteSource bool) (*OpReport, error) {
+	report := &OpReport{Op: fmt.Sprintf("merge role %d into %d", sourceId, targetId)}
+	if sourceId == targetId {
+		return report, errors.New("cannot merge a role into itself")
+	}
+	forest, err := o.forest()
+	if err != nil {
+		return report, err
+	}
+	source := forest.Find(sourceId)
+	if source == nil {
+		return report, &RoleNotFoundError{RoleId: sourceId}
+	}
+	if forest.Find(targetId) == nil {
+		return report, &RoleNotFoundError{RoleId: targetId}
+	}
+	if deleteSource {
+		for _, d := range forest.Descendants(sourceId) {
+			if d.Id == targetId {
+				return report, ErrRoleCycle
+			}
+		}
+	}
+
+	targetUsers, err := o.api.GetUsersOfRole(targetId)
+	report.record("GetUsersOfRole", len(targetUsers), err, targetId)
+	if err != nil {
+		return report, err
+	}
+	exists := make(map[string]bool, len(targetUsers))
+	for _, u := range targetUsers {
+		exists[u.UserId] = true
+	}
+	if err := o.copyMembers(sourceId, targetId, exists, report); err != nil {
+		return report, err
+	}
+
+	if err := o.mergeRelations(sourceId, targetId, report); err != nil {
+		return report, err
+	}
+
+	if !deleteSource {
+		return report, nil
+	}
+	for _, child := range source.Children {
+		_, err := o.api.UpdateRole(child.Id, child.Name, child.Description, targetId)
+		report.record("UpdateRole", nil, err, child.Id, child.Name, child.Description, targetId)
+		if err != nil {
+			return report, err
+		}
+	}
+	_, err = o.api.DeleteRole(sourceId)
+	report.record("DeleteRole", nil, err, sourceId)
+	return report, err
+}
+
+func (o *RoleOps) mergeRelations(sourceId, targetId int, report *OpReport) error {
+	sourceRel, err := o.api.GetRelatedInfo(sourceId)
+	report.record("GetRelatedInfo", len(sourceRel), err, sourceId)
+	if err != nil {
+		return err
+	}
+	targetRel, err := o.api.GetRelatedInfo(targetId)
+	report.record("GetRelatedInfo", len(targetRel), err, targetId)
+	if err != nil {
+		return err
+	}
+	exists := make(map[int]bool, len(targetRel))
+	for _, r := range targetRel {
+		exists[r.ResourceId] = true
+	}
+	var resIds []int
+	for _, r := range sourceRel {
+		if !exists[r.ResourceId] {
+			exists[r.ResourceId] = true
+			resIds = append(resIds, r.ResourceId)
+		}
+	}
+	if len(resIds) == 0 {
+		return nil
+	}
+	sort.Ints(resIds)
+	n, err := o.api.AddRelations(targetId, resIds)
+	report.record("AddRelations", n, err, targetId, resIds)
+	return err
+}
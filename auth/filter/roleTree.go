@@ -0,0 +1,373 @@
+package filter
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// 遍历时返回ErrSkipChildren跳过当前节点的子节点
+var ErrSkipChildren = errors.New("skip children")
+
+const ROLE_PATH_SEPARATOR = "/"
+
+// 角色森林（GetRoleTree的结果或由BuildRoleTree构建），提供遍历和查找
+type RoleForest []*RoleTree
+
+// 构建角色树时发现的问题
+type RoleTreeIssues struct {
+	Orphans  []*Role // 父角色不存在的角色，作为根节点保留在树中
+	Cycles   [][]int // 父子关系成环的角色id，环中的角色不在树中
+	Detached []*Role // 父角色在环中的角色，作为根节点保留在树中
+}
+
+func (i *RoleTreeIssues) Empty() bool {
+	return len(i.Orphans) == 0 && len(i.Cycles) == 0 && len(i.Detached) == 0
+}
+
+func apiResourcesToResources(apiResources []*ApiResource) []*Resource {
+	if apiResources == nil {
+		return nil
+	}
+	resources := make([]*Resource, 0, len(apiResources))
+	for _, r := range apiResources {
+		resources = append(resources, &Resource{Id: int64(r.Id), Description: r.Description, Data: r.Data})
+	}
+	return resources
+}
+
+// 由GetAllRole的平铺结果构建角色树，ParentId为0的角色为根节点，同级按id排序
+func BuildRoleTree(roles []*Role) (RoleForest, *RoleTreeIssues) {
+	issues := &RoleTreeIssues{Orphans: FindOrphanRoles(roles), Cycles: DetectRoleCycles(roles)}
+	inCycle := make(map[int]bool)
+	for _, cycle := range issues.Cycles {
+		for _, id := range cycle {
+			inCycle[id] = true
+		}
+	}
+	orphan := make(map[int]bool)
+	for _, r := range issues.Orphans {
+		orphan[r.Id] = true
+	}
+
+	nodes := make(map[int]*RoleTree, len(roles))
+	for _, r := range roles {
+		nodes[r.Id] = &RoleTree{
+			Id:          r.Id,
+			Name:        r.Name,
+			Description: r.Description,
+			ParentId:    r.ParentId,
+			Created:     r.Created,
+			Updated:     r.Updated,
+			Resources:   apiResourcesToResources(r.Resources),
+			Users:       r.Users,
+		}
+	}
+	var roots RoleForest
+	for _, r := range roles {
+		node := nodes[r.Id]
+		switch {
+		case inCycle[r.Id]:
+		case r.ParentId == 0 || orphan[r.Id]:
+			roots = append(roots, node)
+		case inCycle[r.ParentId]:
+			// 父角色不在树中，提升为根节点以保留其子树
+			issues.Detached = append(issues.Detached, r)
+			roots = append(roots, node)
+		default:
+			parent := nodes[r.ParentId]
+			parent.Children = append(parent.Children, node)
+		}
+	}
+	sortRoleTrees(roots)
+	for _, node := range nodes {
+		sortRoleTrees(node.Children)
+	}
+	return roots, issues
+}
+
+func sortRoleTrees(trees []*RoleTree) {
+	sort.Slice(trees, func(i, j int) bool { return trees[i].Id < trees[j].Id })
+}
+
+// 父角色不存在的角色
+func FindOrphanRoles(roles []*Role) []*Role {
+	ids := make(map[int]bool, len(roles))
+	for _, r := range roles {
+		ids[r.Id] = true
+	}
+	var orphans []*Role
+	for _, r := range roles {
+		if r.ParentId != 0 && !ids[r.ParentId] {
+			orphans = append(orphans, r)
+		}
+	}
+	return orphans
+}
+
+// 沿ParentId查找成环的角色，每个环从最小的id开始
+func DetectRoleCycles(roles []*Role) [][]int {
+	parent := make(map[int]int, len(roles))
+	for _, r := range roles {
+		parent[r.Id] = r.ParentId
+	}
+	ids := make([]int, 0, len(parent))
+	for id := range parent {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var cycles [][]int
+	done := make(map[int]bool)
+	for _, start := range ids {
+		onPath := make(map[int]int) // id - 在path中的位置
+		var path []int
+		for id := start; id != 0 && !done[id]; id = parent[id] {
+			if _, ok := parent[id]; !ok {
+				break
+			}
+			if pos, ok := onPath[id]; ok {
+				cycles = append(cycles, normalizeCycle(path[pos:]))
+				break
+			}
+			onPath[id] = len(path)
+			path = append(path, id)
+		}
+		for _, id := range path {
+			done[id] = true
+		}
+	}
+	return cycles
+}
+
+func normalizeCycle(cycle []int) []int {
+	min := 0
+	for i, id := range cycle {
+		if id < cycle[min] {
+			min = i
+		}
+	}
+	return append(append([]int{}, cycle[min:]...), cycle[:min]...)
+}
+
+// 深度优先（先序）遍历，depth从0开始；fn返回ErrSkipChildren时跳过子节点，返回其他错误时停止遍历
+func (f RoleForest) Walk(fn func(node *RoleTree, depth int) error) error {
+	for _, root := range f {
+		if err := walkRoleTree(root, 0, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkRoleTree(node *RoleTree, depth int, fn func(node *RoleTree, depth int) error) error {
+	if err := fn(node, depth); err == ErrSkipChildren {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, child := range node.Children {
+		if err := walkRoleTree(child, depth+1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 广度优先遍历，按层访问
+func (f RoleForest) WalkBFS(fn func(node *RoleTree, depth int) error) error {
+	type item struct {
+		node  *RoleTree
+		depth int
+	}
+	queue := make([]item, 0, len(f))
+	for _, root := range f {
+		queue = append(queue, item{root, 0})
+	}
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		if err := fn(it.node, it.depth); err == ErrSkipChildren {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, child := range it.node.Children {
+			queue = append(queue, item{child, it.depth + 1})
+		}
+	}
+	return nil
+}
+
+// 从根节点到id对应节点的路径，不存在时返回nil
+func (f RoleForest) pathTo(match func(node *RoleTree) bool) []*RoleTree {
+	var path, found []*RoleTree
+	var visit func(node *RoleTree) bool
+	visit = func(node *RoleTree) bool {
+		path = append(path, node)
+		if match(node) {
+			found = append([]*RoleTree{}, path...)
+			return true
+		}
+		for _, child := range node.Children {
+			if visit(child) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	for _, root := range f {
+		if visit(root) {
+			break
+		}
+	}
+	return found
+}
+
+func (f RoleForest) pathToId(id int) []*RoleTree {
+	return f.pathTo(func(node *RoleTree) bool { return node.Id == id })
+}
+
+func (f RoleForest) Find(id int) *RoleTree {
+	if path := f.pathToId(id); len(path) > 0 {
+		return path[len(path)-1]
+	}
+	return nil
+}
+
+// 按角色名查找（不区分大小写），重名时返回深度优先遍历的第一个
+func (f RoleForest) FindByName(name string) *RoleTree {
+	path := f.pathTo(func(node *RoleTree) bool { return strings.EqualFold(node.Name, name) })
+	if len(path) > 0 {
+		return path[len(path)-1]
+	}
+	return nil
+}
+
+// 祖先角色，从根节点开始，不包含自身
+func (f RoleForest) Ancestors(id int) []*RoleTree {
+	path := f.pathToId(id)
+	if len(path) == 0 {
+		return nil
+	}
+	return path[:len(path)-1]
+}
+
+// 后代角色，深度优先顺序，不包含自身
+func (f RoleForest) Descendants(id int) []*RoleTree {
+	node := f.Find(id)
+	if node == nil {
+		return nil
+	}
+	var descendants []*RoleTree
+	RoleForest(node.Children).Walk(func(n *RoleTree, depth int) error {
+		descendants = append(descendants, n)
+		return nil
+	})
+	return descendants
+}
+
+// 角色路径，如 root/ops/oncall，不存在时返回空串
+func (f RoleForest) Path(id int) string {
+	path := f.pathToId(id)
+	names := make([]string, 0, len(path))
+	for _, node := range path {
+		names = append(names, node.Name)
+	}
+	return strings.Join(names, ROLE_PATH_SEPARATOR)
+}
+
+// 按路径查找，如 root/ops/oncall（不区分大小写）
+func (f RoleForest) FindByPath(path string) *RoleTree {
+	names := strings.Split(strings.Trim(path, ROLE_PATH_SEPARATOR), ROLE_PATH_SEPARATOR)
+	level := []*RoleTree(f)
+	var node *RoleTree
+	for _, name := range names {
+		node = nil
+		for _, n := range level {
+			if strings.EqualFold(n.Name, name) {
+				node = n
+				break
+			}
+		}
+		if node == nil {
+			return nil
+		}
+		level = node.Children
+	}
+	return node
+}
+
+// 深度优先顺序的全部角色
+func (f RoleForest) Flatten() []*RoleTree {
+	var nodes []*RoleTree
+	f.Walk(func(node *RoleTree, depth int) error {
+		nodes = append(nodes, node)
+		return nil
+	})
+	return nodes
+}
+
+// 角色的有效资源：自身及全部祖先角色的资源（子角色继承祖先角色的资源），按资源id去重
+func (f RoleForest) EffectiveResources(id int) []*Resource {
+	path := f.pathToId(id)
+	var resources []*Resource
+	seen := make(map[int64]bool)
+	for _, node := range path {
+		resources = appendUniqueResources(resources, node.Resources, seen)
+	}
+	return resources
+}
+
+// 全部角色的有效资源，角色id - 资源
+func (f RoleForest) EffectiveResourceMap() map[int][]*Resource {
+	effective := make(map[int][]*Resource)
+	var visit func(node *RoleTree, inherited []*Resource)
+	visit = func(node *RoleTree, inherited []*Resource) {
+		seen := make(map[int64]bool, len(inherited))
+		resources := appendUniqueResources(nil, inherited, seen)
+		resources = appendUniqueResources(resources, node.Resources, seen)
+		effective[node.Id] = resources
+		for _, child := range node.Children {
+			visit(child, resources)
+		}
+	}
+	for _, root := range f {
+		visit(root, nil)
+	}
+	return effective
+}
+
+func appendUniqueResources(dst, src []*Resource, seen map[int64]bool) []*Resource {
+	for _, r := range src {
+		if !seen[r.Id] {
+			seen[r.Id] = true
+			dst = append(dst, r)
+		}
+	}
+	return dst
+}
+
+// 将GetUserRoleTree的结果转换为RoleForest，以使用相同的遍历和查找
+func UserRoleForest(trees []*UserRoleTree) RoleForest {
+	if trees == nil {
+		return nil
+	}
+	forest := make(RoleForest, 0, len(trees))
+	for _, t := range trees {
+		forest = append(forest, &RoleTree{
+			Id:          t.Id,
+			Name:        t.Name,
+			Description: t.Description,
+			ParentId:    t.ParentId,
+			Created:     t.Created,
+			Updated:     t.Updated,
+			RoleType:    t.RoleType,
+			Resources:   apiResourcesToResources(t.Resources),
+			Users:       t.Users,
+			Children:    UserRoleForest(t.Children),
+		})
+	}
+	return forest
+}
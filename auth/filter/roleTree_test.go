@@ -0,0 +1,176 @@
+package filter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// 1 root
+// ├── 2 ops
+// │   └── 3 oncall
+// └── 4 dev
+// 5 orphan（父角色99不存在）
+// 6 ⇄ 7 成环，8的父角色为6；9的父角色为自身
+func testRoles() []*Role {
+	return []*Role{
+		{Id: 4, Name: "dev", ParentId: 1},
+		{Id: 3, Name: "oncall", ParentId: 2},
+		{Id: 1, Name: "root"},
+		{Id: 2, Name: "ops", ParentId: 1},
+		{Id: 5, Name: "orphan", ParentId: 99},
+		{Id: 7, Name: "cycle-b", ParentId: 6},
+		{Id: 6, Name: "cycle-a", ParentId: 7},
+		{Id: 8, Name: "detached", ParentId: 6},
+		{Id: 9, Name: "self", ParentId: 9},
+	}
+}
+
+func roleIds(roles []*Role) []int {
+	ids := []int{}
+	for _, r := range roles {
+		ids = append(ids, r.Id)
+	}
+	return ids
+}
+
+func treeIds(trees []*RoleTree) []int {
+	ids := []int{}
+	for _, t := range trees {
+		ids = append(ids, t.Id)
+	}
+	return ids
+}
+
+func TestBuildRoleTreeIssues(t *testing.T) {
+	forest, issues := BuildRoleTree(testRoles())
+	if got := roleIds(issues.Orphans); !reflect.DeepEqual(got, []int{5}) {
+		t.Errorf("Orphans = %v, want [5]", got)
+	}
+	if !reflect.DeepEqual(issues.Cycles, [][]int{{6, 7}, {9}}) {
+		t.Errorf("Cycles = %v, want [[6 7] [9]]", issues.Cycles)
+	}
+	if got := roleIds(issues.Detached); !reflect.DeepEqual(got, []int{8}) {
+		t.Errorf("Detached = %v, want [8]", got)
+	}
+	if issues.Empty() {
+		t.Error("Empty = true, want false")
+	}
+	if got := treeIds(forest); !reflect.DeepEqual(got, []int{1, 5, 8}) {
+		t.Errorf("roots = %v, want [1 5 8]", got)
+	}
+	for _, id := range []int{6, 7, 9} {
+		if forest.Find(id) != nil {
+			t.Errorf("role %d in a cycle should not be in the tree", id)
+		}
+	}
+
+	_, issues = BuildRoleTree([]*Role{{Id: 1, Name: "root"}, {Id: 2, Name: "ops", ParentId: 1}})
+	if !issues.Empty() {
+		t.Errorf("issues = %+v, want empty", issues)
+	}
+}
+
+func TestRoleForestLookup(t *testing.T) {
+	forest, _ := BuildRoleTree(testRoles())
+	cases := []struct {
+		id          int
+		ancestors   []int
+		descendants []int
+		path        string
+	}{
+		{1, []int{}, []int{2, 3, 4}, "root"},
+		{2, []int{1}, []int{3}, "root/ops"},
+		{3, []int{1, 2}, []int{}, "root/ops/oncall"},
+		{8, []int{}, []int{}, "detached"},
+		{6, []int{}, []int{}, ""},
+		{100, []int{}, []int{}, ""},
+	}
+	for _, c := range cases {
+		if got := treeIds(forest.Ancestors(c.id)); !reflect.DeepEqual(got, c.ancestors) {
+			t.Errorf("Ancestors(%d) = %v, want %v", c.id, got, c.ancestors)
+		}
+		if got := treeIds(forest.Descendants(c.id)); !reflect.DeepEqual(got, c.descendants) {
+			t.Errorf("Descendants(%d) = %v, want %v", c.id, got, c.descendants)
+		}
+		if got := forest.Path(c.id); got != c.path {
+			t.Errorf("Path(%d) = %q, want %q", c.id, got, c.path)
+		}
+		if c.path == "" {
+			continue
+		}
+		if node := forest.FindByPath("/" + c.path + "/"); node == nil || node.Id != c.id {
+			t.Errorf("FindByPath(%q) = %v, want %d", c.path, node, c.id)
+		}
+	}
+	if node := forest.FindByPath("ROOT/Ops/ONCALL"); node == nil || node.Id != 3 {
+		t.Errorf("FindByPath ignoring case = %v, want 3", node)
+	}
+	if node := forest.FindByPath("root/oncall"); node != nil {
+		t.Errorf("FindByPath(root/oncall) = %d, want nil", node.Id)
+	}
+	if node := forest.FindByName("OnCall"); node == nil || node.Id != 3 {
+		t.Errorf("FindByName = %v, want 3", node)
+	}
+}
+
+func TestRoleForestWalk(t *testing.T) {
+	forest, _ := BuildRoleTree(testRoles())
+	stop := errors.New("stop")
+	type visit struct{ id, depth int }
+	cases := []struct {
+		name    string
+		bfs     bool
+		fn      func(node *RoleTree) error
+		want    []visit
+		wantErr error
+	}{
+		{"dfs", false, func(*RoleTree) error { return nil },
+			[]visit{{1, 0}, {2, 1}, {3, 2}, {4, 1}, {5, 0}, {8, 0}}, nil},
+		{"dfs skip children", false, func(n *RoleTree) error {
+			if n.Id == 2 {
+				return ErrSkipChildren
+			}
+			return nil
+		}, []visit{{1, 0}, {2, 1}, {4, 1}, {5, 0}, {8, 0}}, nil},
+		{"dfs stop", false, func(n *RoleTree) error {
+			if n.Id == 3 {
+				return stop
+			}
+			return nil
+		}, []visit{{1, 0}, {2, 1}, {3, 2}}, stop},
+		{"bfs", true, func(*RoleTree) error { return nil },
+			[]visit{{1, 0}, {5, 0}, {8, 0}, {2, 1}, {4, 1}, {3, 2}}, nil},
+		{"bfs skip children", true, func(n *RoleTree) error {
+			if n.Id == 2 {
+				return ErrSkipChildren
+			}
+			return nil
+		}, []visit{{1, 0}, {5, 0}, {8, 0}, {2, 1}, {4, 1}}, nil},
+		{"bfs stop", true, func(n *RoleTree) error {
+			if n.Id == 8 {
+				return stop
+			}
+			return nil
+		}, []visit{{1, 0}, {5, 0}, {8, 0}}, stop},
+	}
+	for _, c := range cases {
+		var got []visit
+		fn := func(node *RoleTree, depth int) error {
+			got = append(got, visit{node.Id, depth})
+			return c.fn(node)
+		}
+		var err error
+		if c.bfs {
+			err = forest.WalkBFS(fn)
+		} else {
+			err = forest.Walk(fn)
+		}
+		if err != c.wantErr {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.wantErr)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: visited %v, want %v", c.name, got, c.want)
+		}
+	}
+}
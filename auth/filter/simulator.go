@@ -0,0 +1,184 @@
+package filter
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 模拟的一次访问
+type SimRoute struct {
+	Method string `json:"method"`
+	Route  string `json:"route"` // 路由规则，与CheckAuthorityFilter的routerPattern一致
+}
+
+// 规则变化导致的访问权限变化
+type AccessChange struct {
+	UserId string   `json:"user_id"`
+	Route  SimRoute `json:"route"`
+	Before Decision `json:"before"`
+	After  Decision `json:"after"`
+}
+
+func (c *AccessChange) Gained() bool {
+	return !c.Before.Allowed && c.After.Allowed
+}
+
+var ErrNoSimUsers = errors.New("no users found in client roles")
+
+// 离线模拟鉴权：用ApiAuthService加载用户的资源和角色，按Auth的规则计算能否访问
+//
+// Auth配置了ResourceProvider（如PolicyPoint）时资源取自ResourceProvider，与CheckAuthorityFilter一致；
+// 否则取自GetUserResources，相当于线上向sso请求userResources的结果。ResourceCache和登录缓存的过期、
+// 后台刷新不参与模拟，线上在缓存刷新前的判定可能与模拟结果不同。
+type Simulator struct {
+	api   ApiAuthService
+	mu    sync.Mutex
+	users map[string]User
+}
+
+func NewSimulator(api ApiAuthService) *Simulator {
+	return &Simulator{api: api, users: make(map[string]User)}
+}
+
+// 通过ApiAuthService加载用户的资源和角色
+func loadUserFromApi(api ApiAuthService, user *User, withRoles bool) error {
+	apiResources, err := api.GetUserResources(user.Id)
+	if err != nil {
+		return err
+	}
+	resources := make([]*Resource, 0, len(apiResources))
+	for _, r := range apiResources {
+		resources = append(resources, &Resource{Id: int64(r.Id), Description: r.Description, Data: r.Data})
+	}
+	user.setResources(resources)
+	if withRoles {
+		roles, err := api.GetUserRoles(user.Id, true, false, false)
+		if err != nil {
+			return err
+		}
+		user.setRoles(roles)
+	}
+	return nil
+}
+
+// 加载的用户会被缓存，同一Simulator多次模拟时不重复请求
+func (s *Simulator) User(userId string) (User, error) {
+	s.mu.Lock()
+	user, ok := s.users[userId]
+	s.mu.Unlock()
+	if ok {
+		return user, nil
+	}
+	user = User{Id: userId, Fullname: userId}
+	if err := loadUserFromApi(s.api, &user, true); err != nil {
+		return user, err
+	}
+	s.mu.Lock()
+	s.users[userId] = user
+	s.mu.Unlock()
+	return user, nil
+}
+
+// 用户能否以method访问route，属性规则中依赖请求的条件按空值计算
+func (s *Simulator) Check(auth *Auth, userId, method, route string) (Decision, error) {
+	return s.CheckRequest(auth, userId, method, route, RequestAttrs{})
+}
+
+func (s *Simulator) CheckRequest(auth *Auth, userId, method, route string, attrs RequestAttrs) (Decision, error) {
+	user, err := s.userFor(auth, userId)
+	if err != nil {
+		return Decision{}, err
+	}
+	return auth.Decide(user, method, route, attrs), nil
+}
+
+// 按auth的资源来源加载用户，auth配置了ResourceProvider时以其结果替换资源
+func (s *Simulator) userFor(auth *Auth, userId string) (User, error) {
+	user, err := s.User(userId)
+	if err != nil || auth.ResourceProvider == nil {
+		return user, err
+	}
+	resources, err := auth.ResourceProvider.ResourcesForUser(userId)
+	if err != nil {
+		return user, err
+	}
+	user.setResources(resources)
+	return user, nil
+}
+
+// client下全部角色中的用户，没有任何用户时返回ErrNoSimUsers，避免Diff在查询异常时静默返回无变化
+func (s *Simulator) AllUsers() ([]string, error) {
+	roles, err := s.api.GetAllRole(false, false)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, r := range roles {
+		users, err := s.api.GetUsersOfRole(r.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			seen[u.UserId] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil, ErrNoSimUsers
+	}
+	return sortedKeys(seen), nil
+}
+
+// 两组规则中出现的全部路由；只有url的规则按GET模拟
+func RuleRoutes(auths ...*Auth) []SimRoute {
+	keys := make(map[string]bool)
+	for _, a := range auths {
+		for k := range a.UrlControl {
+			keys[k] = true
+		}
+		for k := range a.AbacRules {
+			keys[k] = true
+		}
+	}
+	var routes []SimRoute
+	for _, k := range sortedKeys(keys) {
+		if idx := strings.Index(k, ":/"); idx > 0 {
+			routes = append(routes, SimRoute{Method: strings.ToUpper(k[:idx]), Route: k[idx+1:]})
+		} else {
+			routes = append(routes, SimRoute{Method: "GET", Route: k})
+		}
+	}
+	return routes
+}
+
+// 比较两组规则下全部用户的访问权限，返回有变化的用户和路由；routes为空时使用RuleRoutes
+func (s *Simulator) Diff(before, after *Auth, routes []SimRoute) ([]*AccessChange, error) {
+	users, err := s.AllUsers()
+	if err != nil {
+		return nil, err
+	}
+	if len(routes) == 0 {
+		routes = RuleRoutes(before, after)
+	}
+	var changes []*AccessChange
+	for _, userId := range users {
+		userBefore, err := s.userFor(before, userId)
+		if err != nil {
+			return nil, err
+		}
+		userAfter, err := s.userFor(after, userId)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range routes {
+			b := before.Decide(userBefore, r.Method, r.Route, RequestAttrs{})
+			a := after.Decide(userAfter, r.Method, r.Route, RequestAttrs{})
+			if a.Allowed != b.Allowed {
+				changes = append(changes, &AccessChange{UserId: userId, Route: r, Before: b, After: a})
+			}
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].UserId < changes[j].UserId })
+	return changes, nil
+}
@@ -0,0 +1,45 @@
+package filter
+
+import (
+	"github.com/astaxie/beego/context"
+)
+
+// 登录用户的存储，默认保存在beego session中，多副本部署时可使用CookieUserStore避免共享session后端
+type UserStore interface {
+	Get(ctx *context.Context, key string) (User, bool)
+	Set(ctx *context.Context, key string, user User) error
+	Delete(ctx *context.Context, key string)
+}
+
+// 保存在beego session中
+type sessionUserStore struct{}
+
+func (sessionUserStore) Get(ctx *context.Context, key string) (User, bool) {
+	if ctx.Input.CruSession == nil {
+		return User{}, false
+	}
+	user, ok := ctx.Input.CruSession.Get(key).(User)
+	return user, ok
+}
+
+func (sessionUserStore) Set(ctx *context.Context, key string, user User) error {
+	return ctx.Input.CruSession.Set(key, user)
+}
+
+func (sessionUserStore) Delete(ctx *context.Context, key string) {
+	ctx.Input.CruSession.Delete(key)
+}
+
+func (a *Auth) users() UserStore {
+	if a.UserStore == nil {
+		return sessionUserStore{}
+	}
+	return a.UserStore
+}
+
+// 保存用户，失败时记录日志
+func (a *Auth) saveUser(ctx *context.Context, key string, user User) {
+	if err := a.users().Set(ctx, key, user); err != nil {
+		a.log().Error("save user failed", F("user_id", user.Id), F("error", err))
+	}
+}
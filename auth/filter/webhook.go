@@ -0,0 +1,266 @@
+package filter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HEADER_WEBHOOK_SIGNATURE = "X-Auth-Signature" // sha256=hex(hmac_sha256(client secret, body))
+	DEFAULT_WEBHOOK_WINDOW   = 5 * time.Minute
+	webhookMaxBody           = 1 << 20
+)
+
+// 权限变更类型
+const (
+	CHANGE_USER     = "user"
+	CHANGE_ROLE     = "role"
+	CHANGE_RESOURCE = "resource"
+)
+
+// sso推送的权限变更通知
+type PermissionChange struct {
+	Type       string   `json:"type"`        // user、role或resource
+	UserIds    []string `json:"user_ids"`    // 受影响的用户，为空时视为全部用户
+	RoleId     int      `json:"role_id"`     // type为role时的角色id
+	ResourceId int      `json:"resource_id"` // type为resource时的资源id
+	Timestamp  int64    `json:"timestamp"`   // 发送时间（unix秒）
+	Nonce      string   `json:"nonce"`       // 随机串，同一通知不能重复使用
+}
+
+// 权限失效时间（unix纳秒），session中LoadTime不晚于失效时间的用户会在下次请求时重新加载
+type invalidations struct {
+	mu    sync.RWMutex
+	all   int64
+	users map[string]int64
+}
+
+func newInvalidations() *invalidations {
+	return &invalidations{users: make(map[string]int64)}
+}
+
+func (i *invalidations) invalidate(userIds []string, at int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(userIds) == 0 {
+		i.all = at
+		// 全部失效后单个用户的记录不再需要
+		i.users = make(map[string]int64)
+		return
+	}
+	for _, id := range userIds {
+		i.users[id] = at
+	}
+}
+
+func (i *invalidations) stale(user *User) bool {
+	if i == nil {
+		return false
+	}
+	loaded := user.LoadTime
+	if loaded == 0 {
+		// 没有LoadTime的旧session按CacheTime（秒）比较
+		loaded = user.CacheTime * int64(time.Second)
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return loaded <= i.all || loaded <= i.users[user.Id]
+}
+
+// 可在权限变更时立即刷新的ResourceProvider，如PolicyPoint
+type refresher interface {
+	Refresh() error
+}
+
+// 接收sso的权限变更通知，校验签名后使相关用户的资源缓存失效
+//
+// client secret为空时无法校验签名，拒绝全部通知。
+//
+//	beego.Handler("/auth/permission-changed", auth.(*filter.Auth).PermissionWebhook())
+type PermissionWebhook struct {
+	auth   *Auth
+	secret []byte
+	window time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]int64 // nonce - 过期时间
+	now    func() time.Time
+}
+
+// 以client secret校验签名的webhook
+func (a *Auth) PermissionWebhook() *PermissionWebhook {
+	return &PermissionWebhook{
+		auth:   a,
+		secret: []byte(a.ClientSecret),
+		window: DEFAULT_WEBHOOK_WINDOW,
+		nonces: make(map[string]int64),
+		now:    time.Now,
+	}
+}
+
+// 设置通知时间与本地时间允许的最大偏差
+func (h *PermissionWebhook) SetWindow(window time.Duration) {
+	h.window = window
+}
+
+func (h *PermissionWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(h.secret) == 0 {
+		h.auth.log().Error("permission webhook rejected", F("reason", "client secret is empty"))
+		http.Error(w, "webhook is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBody))
+	if err != nil {
+		http.Error(w, "read body failed", http.StatusBadRequest)
+		return
+	}
+	if !h.verify(body, r.Header.Get(HEADER_WEBHOOK_SIGNATURE)) {
+		h.auth.log().Warn("permission webhook signature mismatch", F("ip", r.RemoteAddr))
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var change PermissionChange
+	if err := json.Unmarshal(body, &change); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if err := h.checkReplay(&change); err != "" {
+		h.auth.log().Warn("permission webhook rejected", F("reason", err), F("nonce", change.Nonce))
+		http.Error(w, err, http.StatusUnauthorized)
+		return
+	}
+	switch change.Type {
+	case CHANGE_USER, CHANGE_ROLE, CHANGE_RESOURCE:
+	default:
+		http.Error(w, "unknown change type", http.StatusBadRequest)
+		return
+	}
+	h.auth.InvalidateUsers(change.UserIds...)
+	h.auth.log().Info("permission changed", F("type", change.Type), F("users", change.UserIds), F("role_id", change.RoleId), F("resource_id", change.ResourceId))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *PermissionWebhook) verify(body []byte, signature string) bool {
+	if len(h.secret) == 0 {
+		return false
+	}
+	signature = strings.TrimPrefix(signature, "sha256=")
+	expected, err := hex.DecodeString(signature)
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// 校验时间窗口和nonce，返回拒绝原因
+func (h *PermissionWebhook) checkReplay(change *PermissionChange) string {
+	if change.Nonce == "" {
+		return "nonce is empty"
+	}
+	now := h.now()
+	sent := time.Unix(change.Timestamp, 0)
+	if sent.Before(now.Add(-h.window)) || sent.After(now.Add(h.window)) {
+		return "timestamp out of window"
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for nonce, expire := range h.nonces {
+		if expire < now.Unix() {
+			delete(h.nonces, nonce)
+		}
+	}
+	if _, ok := h.nonces[change.Nonce]; ok {
+		return "nonce is replayed"
+	}
+	// nonce保留到时间窗口之外，之后的重放会因时间戳被拒绝
+	h.nonces[change.Nonce] = sent.Add(h.window).Unix()
+	return ""
+}
+
+// 使指定用户的资源缓存失效，userIds为空时使全部用户失效；各session在下次请求时重新加载
+// ApiAuth为CachedApiAuth时清空其缓存；ResourceProvider可刷新（如PolicyPoint）时在后台刷新，
+// 刷新完成后再次使这些用户失效，避免刷新前重新加载的用户一直使用旧的权限
+func (a *Auth) InvalidateUsers(userIds ...string) {
+	if c, ok := a.ApiAuth.(*CachedApiAuth); ok {
+		c.Invalidate()
+	}
+	a.invalidateUsers(userIds)
+	if r, ok := a.ResourceProvider.(refresher); ok {
+		a.refreshProvider(r, userIds)
+	}
+}
+
+func (a *Auth) invalidateUsers(userIds []string) {
+	a.invalidations.invalidate(userIds, time.Now().UnixNano())
+	if a.ResourceCache != nil {
+		if len(userIds) == 0 {
+			a.ResourceCache.Purge()
+		}
+		for _, id := range userIds {
+			a.ResourceCache.Invalidate(id)
+		}
+	}
+}
+
+// 后台刷新ResourceProvider，同一时间只有一个刷新在进行
+// 刷新期间的通知只记录受影响的用户，当前刷新结束后合并为一次刷新
+type providerRefresh struct {
+	mu      sync.Mutex
+	running bool
+	pending bool
+	all     bool
+	users   []string
+}
+
+func (a *Auth) refreshProvider(r refresher, userIds []string) {
+	p := &a.refreshing
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = true
+	if len(userIds) == 0 {
+		p.all = true
+	} else {
+		p.users = append(p.users, userIds...)
+	}
+	if !p.running {
+		p.running = true
+		go a.runRefresh(r)
+	}
+}
+
+func (a *Auth) runRefresh(r refresher) {
+	p := &a.refreshing
+	for {
+		p.mu.Lock()
+		if !p.pending {
+			p.running = false
+			p.mu.Unlock()
+			return
+		}
+		all, users := p.all, p.users
+		p.pending, p.all, p.users = false, false, nil
+		p.mu.Unlock()
+
+		if err := r.Refresh(); err != nil {
+			a.log().Warn("refresh resource provider failed", F("error", err))
+			continue
+		}
+		if all {
+			users = nil
+		}
+		a.invalidateUsers(users)
+	}
+}
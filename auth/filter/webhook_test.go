@@ -0,0 +1,185 @@
+package filter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newTestWebhook(now time.Time) *PermissionWebhook {
+	return &PermissionWebhook{
+		secret: []byte("secret"),
+		window: DEFAULT_WEBHOOK_WINDOW,
+		nonces: make(map[string]int64),
+		now:    func() time.Time { return now },
+	}
+}
+
+func TestWebhookVerify(t *testing.T) {
+	body := `{"type":"user","user_ids":["u1"]}`
+	cases := []struct {
+		name      string
+		body      string
+		signature string
+		want      bool
+	}{
+		{"valid", body, sign("secret", body), true},
+		{"without prefix", body, sign("secret", body)[len("sha256="):], true},
+		{"wrong secret", body, sign("other", body), false},
+		{"tampered body", body + " ", sign("secret", body), false},
+		{"empty", body, "", false},
+		{"not hex", body, "sha256=xyz", false},
+		{"truncated", body, sign("secret", body)[:20], false},
+	}
+	h := newTestWebhook(time.Now())
+	for _, c := range cases {
+		if got := h.verify([]byte(c.body), c.signature); got != c.want {
+			t.Errorf("%s: verify = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestWebhookCheckReplay(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	h := newTestWebhook(now)
+	cases := []struct {
+		name      string
+		nonce     string
+		timestamp int64
+		want      string
+	}{
+		{"accepted", "n1", now.Unix(), ""},
+		{"replayed", "n1", now.Unix(), "nonce is replayed"},
+		{"empty nonce", "", now.Unix(), "nonce is empty"},
+		{"too old", "n2", now.Add(-DEFAULT_WEBHOOK_WINDOW - time.Second).Unix(), "timestamp out of window"},
+		{"in future", "n3", now.Add(DEFAULT_WEBHOOK_WINDOW + time.Second).Unix(), "timestamp out of window"},
+		{"edge of window", "n4", now.Add(-DEFAULT_WEBHOOK_WINDOW).Unix(), ""},
+		{"another nonce", "n5", now.Unix(), ""},
+	}
+	for _, c := range cases {
+		change := &PermissionChange{Type: CHANGE_USER, Nonce: c.nonce, Timestamp: c.timestamp}
+		if got := h.checkReplay(change); got != c.want {
+			t.Errorf("%s: checkReplay = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestInvalidationsStale(t *testing.T) {
+	at := time.Unix(1600000000, 500)
+	i := newInvalidations()
+	i.invalidate([]string{"u1"}, at.UnixNano())
+	cases := []struct {
+		name string
+		user User
+		want bool
+	}{
+		{"loaded before", User{Id: "u1", LoadTime: at.UnixNano() - 1}, true},
+		{"loaded after in same second", User{Id: "u1", CacheTime: at.Unix(), LoadTime: at.UnixNano() + 1}, false},
+		{"other user", User{Id: "u2", LoadTime: at.UnixNano() - 1}, false},
+		{"legacy session", User{Id: "u1", CacheTime: at.Unix() - 1}, true},
+	}
+	for _, c := range cases {
+		if got := i.stale(&c.user); got != c.want {
+			t.Errorf("%s: stale = %v, want %v", c.name, got, c.want)
+		}
+	}
+	i.invalidate(nil, at.UnixNano()+10)
+	if !i.stale(&User{Id: "u2", LoadTime: at.UnixNano() + 5}) {
+		t.Error("invalidate all: user loaded before should be stale")
+	}
+}
+
+func TestWebhookEmptySecret(t *testing.T) {
+	h := (&Auth{invalidations: newInvalidations()}).PermissionWebhook()
+	body := `{"type":"user","user_ids":["u1"],"nonce":"n1","timestamp":` + strconv.FormatInt(time.Now().Unix(), 10) + `}`
+	r := httptest.NewRequest(http.MethodPost, "/auth/permission-changed", strings.NewReader(body))
+	r.Header.Set(HEADER_WEBHOOK_SIGNATURE, sign("", body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if h.verify([]byte(body), sign("", body)) {
+		t.Error("verify should fail with an empty secret")
+	}
+}
+
+// 每次Refresh阻塞到release收到值
+type blockingRefresher struct {
+	started chan struct{}
+	release chan struct{}
+	count   int32
+}
+
+func (r *blockingRefresher) Refresh() error {
+	atomic.AddInt32(&r.count, 1)
+	r.started <- struct{}{}
+	<-r.release
+	return nil
+}
+
+func (r *blockingRefresher) ResourcesForUser(userId string) ([]*Resource, error) {
+	return nil, nil
+}
+
+func TestInvalidateUsersRefreshInBackground(t *testing.T) {
+	r := &blockingRefresher{started: make(chan struct{}), release: make(chan struct{})}
+	a := &Auth{ResourceProvider: r, invalidations: newInvalidations()}
+	returned := make(chan struct{})
+	go func() {
+		a.InvalidateUsers("u1")
+		<-r.started
+		// 刷新进行中的通知不阻塞，合并为一次刷新
+		a.InvalidateUsers("u2")
+		a.InvalidateUsers("u3")
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("InvalidateUsers blocked on a running refresh")
+	}
+	before := time.Now().UnixNano()
+	r.release <- struct{}{}
+	select {
+	case <-r.started:
+	case <-time.After(time.Second):
+		t.Fatal("pending refresh did not start")
+	}
+	r.release <- struct{}{}
+	deadline := time.Now().Add(time.Second)
+	for {
+		a.refreshing.mu.Lock()
+		running := a.refreshing.running
+		a.refreshing.mu.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refresh did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&r.count); n != 2 {
+		t.Errorf("Refresh called %d times, want 2", n)
+	}
+	// 刷新完成后再次失效
+	for _, id := range []string{"u2", "u3"} {
+		if !a.invalidations.stale(&User{Id: id, LoadTime: before}) {
+			t.Errorf("%s should be invalidated after refresh", id)
+		}
+	}
+}
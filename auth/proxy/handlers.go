@@ -0,0 +1,376 @@
+package proxy
+
+import (
+	"github.com/tongwu13/golang_common/auth/filter"
+)
+
+// 查询参数中的分页条件，未传任何分页参数时返回false，调用方使用不分页的接口
+func (c *Controller) listOptions() (filter.ListOptions, bool, bool) {
+	opts := filter.ListOptions{
+		Cursor:       c.GetString("cursor"),
+		NamePrefix:   c.GetString("name_prefix"),
+		CreatedBy:    c.GetString("created_by"),
+		UpdatedSince: c.GetString("updated_since"),
+	}
+	paged := opts.Cursor != "" || opts.NamePrefix != "" || opts.CreatedBy != "" || opts.UpdatedSince != ""
+	if c.GetString("page_size") != "" {
+		size, err := c.GetInt("page_size")
+		if err != nil || size <= 0 {
+			return opts, false, false
+		}
+		opts.PageSize = size
+		paged = true
+	}
+	return opts, paged, true
+}
+
+// 返回接口结果
+func (c *Controller) reply(data interface{}, err error) {
+	if err != nil {
+		c.Failed(err)
+		return
+	}
+	c.Ok(data)
+}
+
+// 返回影响的数量；sso接口失败时可能返回负数而没有错误，视为失败
+func (c *Controller) replyCount(count int, err error) {
+	if err == nil && count < 0 {
+		err = filter.ErrInvalidCount
+	}
+	c.reply(map[string]int{"count": count}, err)
+}
+
+// 资源列表，传分页参数时分页查询
+func (c *Controller) ListResources() {
+	if !c.allow(OP_LIST_RESOURCES) {
+		return
+	}
+	opts, paged, ok := c.listOptions()
+	if !ok {
+		c.InvalidParams()
+		return
+	}
+	if paged {
+		c.reply(c.Proxy.Api.ListResources(opts))
+		return
+	}
+	c.reply(c.Proxy.Api.GetAllResources())
+}
+
+func (c *Controller) UserResources() {
+	if !c.allow(OP_USER_RESOURCES) {
+		return
+	}
+	userId, ok := c.pathUserId()
+	if !ok {
+		c.InvalidParams()
+		return
+	}
+	c.reply(c.Proxy.Api.GetUserResources(userId))
+}
+
+// 请求体为[{"name":"","description":"","data":""}]
+func (c *Controller) AddResources() {
+	if !c.allow(OP_ADD_RESOURCES) {
+		return
+	}
+	var resources []filter.ResourceInfo
+	if !c.readJSON(&resources) || len(resources) == 0 || len(resources) > MAX_BATCH_SIZE {
+		c.InvalidParams()
+		return
+	}
+	for _, r := range resources {
+		if !validText(r.Name, MAX_NAME_LEN) || !validText(r.Data, MAX_DATA_LEN) || !validDescription(r.Description) {
+			c.InvalidParams()
+			return
+		}
+	}
+	c.reply(c.Proxy.Api.AddResource(resources))
+}
+
+// 请求体为{"name":"","description":"","data":""}
+func (c *Controller) UpdateResource() {
+	if !c.allow(OP_UPDATE_RESOURCE) {
+		return
+	}
+	id, ok := c.pathId(":id")
+	var r filter.ResourceInfo
+	if !ok || !c.readJSON(&r) || !validText(r.Name, MAX_NAME_LEN) || !validText(r.Data, MAX_DATA_LEN) || !validDescription(r.Description) {
+		c.InvalidParams()
+		return
+	}
+	c.reply(c.Proxy.Api.UpdateResource(id, r.Name, r.Description, r.Data))
+}
+
+// 请求体为{"ids":[]}
+func (c *Controller) DeleteResources() {
+	if !c.allow(OP_DELETE_RESOURCES) {
+		return
+	}
+	var body struct {
+		Ids []int `json:"ids"`
+	}
+	if !c.readJSON(&body) || !validIds(body.Ids) {
+		c.InvalidParams()
+		return
+	}
+	c.reply(c.Proxy.Api.DeleteResources(body.Ids))
+}
+
+// 角色列表，查询参数related_resource、related_user控制是否返回关联的资源和用户，传分页参数时分页查询
+func (c *Controller) ListRoles() {
+	if !c.allow(OP_LIST_ROLES) {
+		return
+	}
+	relatedResource, ok1 := c.queryBool("related_resource")
+	relatedUser, ok2 := c.queryBool("related_user")
+	opts, paged, ok3 := c.listOptions()
+	if !ok1 || !ok2 || !ok3 {
+		c.InvalidParams()
+		return
+	}
+	if paged {
+		c.reply(c.Proxy.Api.ListRoles(relatedResource, relatedUser, opts))
+		return
+	}
+	c.reply(c.Proxy.Api.GetAllRole(relatedResource, relatedUser))
+}
+
+func (c *Controller) RoleTree() {
+	if !c.allow(OP_ROLE_TREE) {
+		return
+	}
+	relatedResource, ok1 := c.queryBool("related_resource")
+	relatedUser, ok2 := c.queryBool("related_user")
+	if !ok1 || !ok2 {
+		c.InvalidParams()
+		return
+	}
+	c.reply(c.Proxy.Api.GetRoleTree(relatedResource, relatedUser))
+}
+
+// 查询参数is_all为true时包含继承的角色
+func (c *Controller) UserRoles() {
+	if !c.allow(OP_USER_ROLES) {
+		return
+	}
+	userId, ok := c.pathUserId()
+	isAll, ok1 := c.queryBool("is_all")
+	relatedResource, ok2 := c.queryBool("related_resource")
+	relatedUser, ok3 := c.queryBool("related_user")
+	if !ok || !ok1 || !ok2 || !ok3 {
+		c.InvalidParams()
+		return
+	}
+	c.reply(c.Proxy.Api.GetUserRoles(userId, isAll, relatedResource, relatedUser))
+}
+
+func (c *Controller) UserRoleTree() {
+	if !c.allow(OP_USER_ROLE_TREE) {
+		return
+	}
+	userId, ok := c.pathUserId()
+	relatedResource, ok1 := c.queryBool("related_resource")
+	relatedUser, ok2 := c.queryBool("related_user")
+	if !ok || !ok1 || !ok2 {
+		c.InvalidParams()
+		return
+	}
+	c.reply(c.Proxy.Api.GetUserRoleTree(userId, relatedResource, relatedUser))
+}
+
+type roleBody struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ParentId    int    `json:"parent_id"` // 0为顶级角色
+}
+
+func (b *roleBody) valid() bool {
+	return validText(b.Name, MAX_NAME_LEN) && validDescription(b.Description) && b.ParentId >= 0
+}
+
+// 请求体为{"name":"","description":"","parent_id":0}，返回新角色的id
+func (c *Controller) AddRole() {
+	if !c.allow(OP_ADD_ROLE) {
+		return
+	}
+	var body roleBody
+	if !c.readJSON(&body) || !body.valid() {
+		c.InvalidParams()
+		return
+	}
+	id, err := c.Proxy.Api.AddRole(body.Name, body.Description, body.ParentId)
+	c.reply(map[string]int{"id": id}, err)
+}
+
+func (c *Controller) UpdateRole() {
+	if !c.allow(OP_UPDATE_ROLE) {
+		return
+	}
+	id, ok := c.pathId(":id")
+	var body roleBody
+	if !ok || !c.readJSON(&body) || !body.valid() || body.ParentId == id {
+		c.InvalidParams()
+		return
+	}
+	c.reply(c.Proxy.Api.UpdateRole(id, body.Name, body.Description, body.ParentId))
+}
+
+func (c *Controller) DeleteRole() {
+	if !c.allow(OP_DELETE_ROLE) {
+		return
+	}
+	id, ok := c.pathId(":id")
+	if !ok {
+		c.InvalidParams()
+		return
+	}
+	c.reply(c.Proxy.Api.DeleteRole(id))
+}
+
+// 角色中的用户，传分页参数时分页查询（name_prefix为用户Id前缀）
+func (c *Controller) RoleUsers() {
+	if !c.allow(OP_LIST_ROLE_USERS) {
+		return
+	}
+	id, ok := c.pathId(":id")
+	opts, paged, ok1 := c.listOptions()
+	if !ok || !ok1 {
+		c.InvalidParams()
+		return
+	}
+	if paged {
+		c.reply(c.Proxy.Api.ListUsersOfRole(id, opts))
+		return
+	}
+	c.reply(c.Proxy.Api.GetUsersOfRole(id))
+}
+
+// 请求体为[{"user_id":"","role_type":""}]
+func (c *Controller) AddRoleUsers() {
+	if !c.allow(OP_ADD_ROLE_USERS) {
+		return
+	}
+	id, ok := c.pathId(":id")
+	var infos []filter.UserInfo
+	if !ok || !c.readJSON(&infos) || len(infos) == 0 || len(infos) > MAX_BATCH_SIZE {
+		c.InvalidParams()
+		return
+	}
+	for _, info := range infos {
+		if !validUserId(info.UserId) || (info.RoleType != "" && !validText(info.RoleType, MAX_NAME_LEN)) {
+			c.InvalidParams()
+			return
+		}
+	}
+	c.replyCount(c.Proxy.Api.AddUserToRole(id, infos))
+}
+
+// 请求体为{"user_id":"","role_type":""}
+func (c *Controller) UpdateRoleUser() {
+	if !c.allow(OP_UPDATE_ROLE_USER) {
+		return
+	}
+	id, ok := c.pathId(":id")
+	var info filter.UserInfo
+	if !ok || !c.readJSON(&info) || !validUserId(info.UserId) || (info.RoleType != "" && !validText(info.RoleType, MAX_NAME_LEN)) {
+		c.InvalidParams()
+		return
+	}
+	c.reply(c.Proxy.Api.UpdateUserOfRole(id, info))
+}
+
+// 请求体为{"user_ids":[]}
+func (c *Controller) DeleteRoleUsers() {
+	if !c.allow(OP_DELETE_ROLE_USERS) {
+		return
+	}
+	id, ok := c.pathId(":id")
+	var body struct {
+		UserIds []string `json:"user_ids"`
+	}
+	if !ok || !c.readJSON(&body) || !validUserIds(body.UserIds) {
+		c.InvalidParams()
+		return
+	}
+	c.replyCount(c.Proxy.Api.DeleteUserFromRole(id, body.UserIds))
+}
+
+// 全部角色的资源关联，传分页参数时分页查询
+func (c *Controller) ListRelations() {
+	if !c.allow(OP_LIST_RELATIONS) {
+		return
+	}
+	opts, paged, ok := c.listOptions()
+	if !ok {
+		c.InvalidParams()
+		return
+	}
+	if paged {
+		c.reply(c.Proxy.Api.ListRelatedInfo(opts))
+		return
+	}
+	c.reply(c.Proxy.Api.GetAllRelatedInfo())
+}
+
+func (c *Controller) RoleRelations() {
+	if !c.allow(OP_ROLE_RELATIONS) {
+		return
+	}
+	id, ok := c.pathId(":id")
+	if !ok {
+		c.InvalidParams()
+		return
+	}
+	c.reply(c.Proxy.Api.GetRelatedInfo(id))
+}
+
+// 请求体为{"res_ids":[]}，allowEmpty为true时允许空列表
+func (c *Controller) relationsBody(allowEmpty bool) (int, []int, bool) {
+	id, ok := c.pathId(":id")
+	var body struct {
+		ResIds []int `json:"res_ids"`
+	}
+	if !ok || !c.readJSON(&body) || (len(body.ResIds) > 0 || !allowEmpty) && !validIds(body.ResIds) {
+		return 0, nil, false
+	}
+	return id, body.ResIds, true
+}
+
+func (c *Controller) AddRelations() {
+	if !c.allow(OP_ADD_RELATIONS) {
+		return
+	}
+	id, resIds, ok := c.relationsBody(false)
+	if !ok {
+		c.InvalidParams()
+		return
+	}
+	c.replyCount(c.Proxy.Api.AddRelations(id, resIds))
+}
+
+// 以res_ids替换角色的全部资源关联，res_ids为空时清空
+func (c *Controller) UpdateRelations() {
+	if !c.allow(OP_UPDATE_RELATIONS) {
+		return
+	}
+	id, resIds, ok := c.relationsBody(true)
+	if !ok {
+		c.InvalidParams()
+		return
+	}
+	c.replyCount(c.Proxy.Api.UpdateRelations(id, resIds))
+}
+
+func (c *Controller) DeleteRelations() {
+	if !c.allow(OP_DELETE_RELATIONS) {
+		return
+	}
+	id, resIds, ok := c.relationsBody(false)
+	if !ok {
+		c.InvalidParams()
+		return
+	}
+	c.replyCount(c.Proxy.Api.DeleteRelations(id, resIds))
+}
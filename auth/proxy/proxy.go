@@ -0,0 +1,155 @@
+// 将ApiAuthService以JSON接口暴露给前端，前端无需持有client secret
+//
+//	proxy.Register(&proxy.Options{
+//		Prefix:          "/api/auth",
+//		Api:             apiAuth,
+//		Auth:            authService,
+//		DefaultResource: "rbac:read",
+//		Resources: map[string]string{
+//			proxy.OP_ADD_ROLE:    "rbac:write",
+//			proxy.OP_DELETE_ROLE: "rbac:admin",
+//		},
+//	})
+//
+// 每个接口对应一个操作，调用方需拥有该操作配置的资源（未配置时使用DefaultResource，两者都为空时拒绝访问）。
+// 返回结构统一为controllers.ResponseBody。client相关接口会暴露secret，不提供代理。
+package proxy
+
+import (
+	"errors"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/tongwu13/golang_common/auth/filter"
+	"github.com/tongwu13/golang_common/beego/controllers"
+	"strings"
+)
+
+// 操作名称，作为Options.Resources的key
+const (
+	OP_LIST_RESOURCES    = "resources.list"
+	OP_USER_RESOURCES    = "resources.user"
+	OP_ADD_RESOURCES     = "resources.add"
+	OP_UPDATE_RESOURCE   = "resources.update"
+	OP_DELETE_RESOURCES  = "resources.delete"
+	OP_LIST_ROLES        = "roles.list"
+	OP_ROLE_TREE         = "roles.tree"
+	OP_USER_ROLES        = "roles.user"
+	OP_USER_ROLE_TREE    = "roles.userTree"
+	OP_ADD_ROLE          = "roles.add"
+	OP_UPDATE_ROLE       = "roles.update"
+	OP_DELETE_ROLE       = "roles.delete"
+	OP_LIST_ROLE_USERS   = "roleUsers.list"
+	OP_ADD_ROLE_USERS    = "roleUsers.add"
+	OP_UPDATE_ROLE_USER  = "roleUsers.update"
+	OP_DELETE_ROLE_USERS = "roleUsers.delete"
+	OP_LIST_RELATIONS    = "relations.list"
+	OP_ROLE_RELATIONS    = "relations.role"
+	OP_ADD_RELATIONS     = "relations.add"
+	OP_UPDATE_RELATIONS  = "relations.update"
+	OP_DELETE_RELATIONS  = "relations.delete"
+)
+
+const DEFAULT_PREFIX = "/api/auth"
+
+var ErrNoResourceConfigured = errors.New("no resource configured for operation")
+
+type Options struct {
+	Prefix          string                // 接口路径前缀，默认为/api/auth
+	Api             filter.ApiAuthService // 被代理的client
+	Auth            filter.AuthService    // 不为空时在接口内调用CheckLoginFilter，否则需由应用自行注册登录过滤器
+	Resources       map[string]string     // 操作 - 所需资源（资源Data、role:xxx或roletype:xxx，多个以|分隔）
+	DefaultResource string                // 未单独配置的操作所需的资源
+}
+
+// 操作所需的规则，Resources和DefaultResource都未配置时返回nil
+func (o *Options) rules(op string) []string {
+	rule, ok := o.Resources[op]
+	if !ok {
+		rule = o.DefaultResource
+	}
+	if rule == "" {
+		return nil
+	}
+	rules := strings.Split(strings.ToLower(rule), "|")
+	for i := range rules {
+		rules[i] = strings.TrimSpace(rules[i])
+	}
+	return rules
+}
+
+// 注册代理接口的路由
+func Register(opts *Options) error {
+	return register(beego.BeeApp.Handlers, opts)
+}
+
+func register(router *beego.ControllerRegister, opts *Options) error {
+	if opts == nil || opts.Api == nil {
+		return errors.New("proxy: Api is required")
+	}
+	prefix := strings.TrimSuffix(opts.Prefix, "/")
+	if prefix == "" {
+		prefix = DEFAULT_PREFIX
+	}
+	routes := []struct {
+		pattern, mapping string
+	}{
+		{"/resources", "get:ListResources;post:AddResources;delete:DeleteResources"},
+		{"/resources/:id:int", "put:UpdateResource"},
+		{"/users/:userId/resources", "get:UserResources"},
+		{"/users/:userId/roles", "get:UserRoles"},
+		{"/users/:userId/roleTree", "get:UserRoleTree"},
+		{"/roles", "get:ListRoles;post:AddRole"},
+		{"/roleTree", "get:RoleTree"},
+		{"/roles/:id:int", "put:UpdateRole;delete:DeleteRole"},
+		{"/roles/:id:int/users", "get:RoleUsers;post:AddRoleUsers;put:UpdateRoleUser;delete:DeleteRoleUsers"},
+		{"/relations", "get:ListRelations"},
+		{"/roles/:id:int/relations", "get:RoleRelations;post:AddRelations;put:UpdateRelations;delete:DeleteRelations"},
+	}
+	for _, r := range routes {
+		router.Add(prefix+r.pattern, &Controller{Proxy: opts}, r.mapping)
+	}
+	return nil
+}
+
+// 可按自身用户存储校验规则的AuthService，filter.Auth和filter.MultiAuth均已实现
+type requirer interface {
+	Require(ctx *context.Context, rules ...string) error
+}
+
+// 代理接口的控制器，由Register注册
+type Controller struct {
+	controllers.BaseController
+	Proxy *Options
+}
+
+func (c *Controller) Prepare() {
+	if c.Proxy.Auth != nil {
+		c.Proxy.Auth.CheckLoginFilter(c.Ctx)
+		if c.Ctx.ResponseWriter.Started {
+			c.StopRun()
+		}
+	}
+}
+
+// 校验当前用户能否执行操作，不能时返回错误响应并返回false
+func (c *Controller) allow(op string) bool {
+	rules := c.Proxy.rules(op)
+	var err error
+	if len(rules) == 0 {
+		err = ErrNoResourceConfigured
+	} else if r, ok := c.Proxy.Auth.(requirer); ok {
+		err = r.Require(c.Ctx, rules...)
+	} else {
+		err = filter.Require(c.Ctx, rules...)
+	}
+	if err == nil {
+		return true
+	}
+	// 保留缺少的规则等细节作为res_msg
+	code := controllers.Forbidden
+	if err == filter.ErrNotLogin {
+		code = controllers.Unauthorized
+	}
+	c.Failed(&controllers.CodedError{Code: code, Message: err.Error(), Err: err})
+	return false
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/tongwu13/golang_common/auth/filter"
+	"github.com/tongwu13/golang_common/beego/controllers"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const headerTestUser = "X-Test-User"
+
+// 按请求头X-Test-User选择用户，未传时视为未登录
+type fakeAuth struct {
+	filter.AuthService
+	users map[string]filter.User
+}
+
+func (f *fakeAuth) CheckLoginFilter(ctx *context.Context) {}
+
+func (f *fakeAuth) Require(ctx *context.Context, rules ...string) error {
+	user, ok := f.users[ctx.Input.Header(headerTestUser)]
+	if !ok {
+		return filter.ErrNotLogin
+	}
+	for _, rule := range rules {
+		if !user.Has(rule) {
+			return &filter.PermissionError{UserId: user.Id, Missing: []string{rule}}
+		}
+	}
+	return nil
+}
+
+func testUser(id string, resources ...string) filter.User {
+	user := filter.User{Id: id, ResourceMap: make(map[string]*filter.Resource)}
+	for _, r := range resources {
+		user.ResourceMap[r] = &filter.Resource{Data: r}
+	}
+	return user
+}
+
+// 记录调用的接口
+type fakeApi struct {
+	filter.ApiAuthService
+	calls []string
+	opts  filter.ListOptions
+	count int
+}
+
+func (f *fakeApi) GetAllResources() ([]*filter.ApiResource, error) {
+	f.calls = append(f.calls, "GetAllResources")
+	return []*filter.ApiResource{}, nil
+}
+
+func (f *fakeApi) ListResources(opts filter.ListOptions) (*filter.ResourcePage, error) {
+	f.calls, f.opts = append(f.calls, "ListResources"), opts
+	return &filter.ResourcePage{}, nil
+}
+
+func (f *fakeApi) GetUserResources(userId string) ([]*filter.ApiResource, error) {
+	f.calls = append(f.calls, "GetUserResources")
+	return []*filter.ApiResource{}, nil
+}
+
+func (f *fakeApi) GetUsersOfRole(roleId int) ([]*filter.RoleUser, error) {
+	f.calls = append(f.calls, "GetUsersOfRole")
+	return []*filter.RoleUser{}, nil
+}
+
+func (f *fakeApi) ListUsersOfRole(roleId int, opts filter.ListOptions) (*filter.RoleUserPage, error) {
+	f.calls, f.opts = append(f.calls, "ListUsersOfRole"), opts
+	return &filter.RoleUserPage{}, nil
+}
+
+func (f *fakeApi) AddRole(name, description string, parentId int) (int, error) {
+	f.calls = append(f.calls, "AddRole")
+	return 7, nil
+}
+
+func (f *fakeApi) AddUserToRole(roleId int, infos []filter.UserInfo) (int, error) {
+	f.calls = append(f.calls, "AddUserToRole")
+	return f.count, nil
+}
+
+func (f *fakeApi) DeleteUserFromRole(roleId int, names []string) (int, error) {
+	f.calls = append(f.calls, "DeleteUserFromRole")
+	return f.count, nil
+}
+
+func newTestProxy(t *testing.T, api *fakeApi) *beego.ControllerRegister {
+	router := beego.NewControllerRegister()
+	err := register(router, &Options{
+		Api: api,
+		Auth: &fakeAuth{users: map[string]filter.User{
+			"reader": testUser("reader", "rbac:read"),
+			"writer": testUser("writer", "rbac:read", "rbac:write"),
+		}},
+		DefaultResource: "rbac:read",
+		Resources: map[string]string{
+			OP_ADD_ROLE:          "rbac:write",
+			OP_ADD_ROLE_USERS:    "rbac:write",
+			OP_DELETE_ROLE_USERS: "rbac:write",
+			OP_DELETE_ROLE:       "", // 显式配置为空时任何人都不能调用
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+type proxyCase struct {
+	name       string
+	method     string
+	target     string
+	user       string
+	body       string
+	wantStatus int
+	wantCode   int
+	wantCalls  []string
+}
+
+func (c *proxyCase) run(t *testing.T, router *beego.ControllerRegister, api *fakeApi) {
+	api.calls = nil
+	r := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+	if c.user != "" {
+		r.Header.Set(headerTestUser, c.user)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	var body controllers.ResponseBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s: invalid response %q: %v", c.name, w.Body.String(), err)
+	}
+	if w.Code != c.wantStatus || body.ResCode != c.wantCode {
+		t.Errorf("%s: status %d res_code %d (%s), want %d %d", c.name, w.Code, body.ResCode, body.ResMsg, c.wantStatus, c.wantCode)
+	}
+	if strings.Join(api.calls, ",") != strings.Join(c.wantCalls, ",") {
+		t.Errorf("%s: calls %v, want %v", c.name, api.calls, c.wantCalls)
+	}
+}
+
+func TestProxyPermissions(t *testing.T) {
+	api := &fakeApi{}
+	router := newTestProxy(t, api)
+	cases := []proxyCase{
+		{name: "not login", method: http.MethodGet, target: "/api/auth/resources", wantStatus: http.StatusUnauthorized, wantCode: controllers.Unauthorized},
+		{name: "default resource", method: http.MethodGet, target: "/api/auth/resources", user: "reader", wantStatus: http.StatusOK, wantCode: controllers.OK, wantCalls: []string{"GetAllResources"}},
+		{name: "missing resource", method: http.MethodPost, target: "/api/auth/roles", user: "reader", body: `{"name":"ops"}`, wantStatus: http.StatusForbidden, wantCode: controllers.Forbidden},
+		{name: "granted resource", method: http.MethodPost, target: "/api/auth/roles", user: "writer", body: `{"name":"ops"}`, wantStatus: http.StatusOK, wantCode: controllers.OK, wantCalls: []string{"AddRole"}},
+		{name: "no resource configured", method: http.MethodDelete, target: "/api/auth/roles/3", user: "writer", wantStatus: http.StatusForbidden, wantCode: controllers.Forbidden},
+		{name: "not login before validation", method: http.MethodGet, target: "/api/auth/users/a$b/resources", wantStatus: http.StatusUnauthorized, wantCode: controllers.Unauthorized},
+	}
+	for i := range cases {
+		cases[i].run(t, router, api)
+	}
+}
+
+func TestProxyInvalidInput(t *testing.T) {
+	api := &fakeApi{count: 1}
+	router := newTestProxy(t, api)
+	large := `[{"user_id":"` + strings.Repeat("a", MAX_BODY_SIZE) + `"}]`
+	cases := []proxyCase{
+		{name: "valid user id", method: http.MethodGet, target: "/api/auth/users/tom.li@example.com/resources", user: "reader", wantStatus: http.StatusOK, wantCode: controllers.OK, wantCalls: []string{"GetUserResources"}},
+		{name: "invalid user id", method: http.MethodGet, target: "/api/auth/users/a$b/resources", user: "reader", wantStatus: http.StatusOK, wantCode: controllers.InvalidParameters},
+		{name: "user id too long", method: http.MethodGet, target: "/api/auth/users/" + strings.Repeat("a", MAX_USER_ID_LEN+1) + "/resources", user: "reader", wantStatus: http.StatusOK, wantCode: controllers.InvalidParameters},
+		{name: "valid body", method: http.MethodPost, target: "/api/auth/roles/3/users", user: "writer", body: `[{"user_id":"tom"}]`, wantStatus: http.StatusOK, wantCode: controllers.OK, wantCalls: []string{"AddUserToRole"}},
+		{name: "empty body", method: http.MethodPost, target: "/api/auth/roles/3/users", user: "writer", wantStatus: http.StatusOK, wantCode: controllers.InvalidParameters},
+		{name: "malformed body", method: http.MethodPost, target: "/api/auth/roles/3/users", user: "writer", body: `[{"user_id":`, wantStatus: http.StatusOK, wantCode: controllers.InvalidParameters},
+		{name: "body too large", method: http.MethodPost, target: "/api/auth/roles/3/users", user: "writer", body: large, wantStatus: http.StatusOK, wantCode: controllers.InvalidParameters},
+		{name: "invalid user id in body", method: http.MethodPost, target: "/api/auth/roles/3/users", user: "writer", body: `[{"user_id":"a&b=c"}]`, wantStatus: http.StatusOK, wantCode: controllers.InvalidParameters},
+		{name: "invalid role id", method: http.MethodPost, target: "/api/auth/roles/0/users", user: "writer", body: `[{"user_id":"tom"}]`, wantStatus: http.StatusOK, wantCode: controllers.InvalidParameters},
+	}
+	for i := range cases {
+		cases[i].run(t, router, api)
+	}
+}
+
+func TestProxyNegativeCount(t *testing.T) {
+	api := &fakeApi{count: -1}
+	router := newTestProxy(t, api)
+	c := proxyCase{name: "negative count", method: http.MethodDelete, target: "/api/auth/roles/3/users", user: "writer", body: `{"user_ids":["tom"]}`,
+		wantStatus: http.StatusOK, wantCode: controllers.InternalError, wantCalls: []string{"DeleteUserFromRole"}}
+	c.run(t, router, api)
+}
+
+func TestProxyPaging(t *testing.T) {
+	api := &fakeApi{}
+	router := newTestProxy(t, api)
+	cases := []struct {
+		proxyCase
+		wantOpts filter.ListOptions
+	}{
+		{proxyCase{name: "unpaged resources", method: http.MethodGet, target: "/api/auth/resources", wantCalls: []string{"GetAllResources"}}, filter.ListOptions{}},
+		{proxyCase{name: "page size", method: http.MethodGet, target: "/api/auth/resources?page_size=10", wantCalls: []string{"ListResources"}}, filter.ListOptions{PageSize: 10}},
+		{proxyCase{name: "filter only", method: http.MethodGet, target: "/api/auth/resources?name_prefix=order", wantCalls: []string{"ListResources"}}, filter.ListOptions{NamePrefix: "order"}},
+		{proxyCase{name: "zero page size", method: http.MethodGet, target: "/api/auth/resources?page_size=0", wantCode: controllers.InvalidParameters}, filter.ListOptions{}},
+		{proxyCase{name: "invalid page size", method: http.MethodGet, target: "/api/auth/resources?page_size=x", wantCode: controllers.InvalidParameters}, filter.ListOptions{}},
+		{proxyCase{name: "unpaged role users", method: http.MethodGet, target: "/api/auth/roles/3/users", wantCalls: []string{"GetUsersOfRole"}}, filter.ListOptions{}},
+		{proxyCase{name: "role users cursor", method: http.MethodGet, target: "/api/auth/roles/3/users?cursor=abc&page_size=5", wantCalls: []string{"ListUsersOfRole"}}, filter.ListOptions{Cursor: "abc", PageSize: 5}},
+	}
+	for _, c := range cases {
+		api.opts = filter.ListOptions{}
+		c.user, c.wantStatus = "reader", http.StatusOK
+		c.run(t, router, api)
+		if api.opts != c.wantOpts {
+			t.Errorf("%s: opts %+v, want %+v", c.name, api.opts, c.wantOpts)
+		}
+	}
+}
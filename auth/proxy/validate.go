@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MAX_BODY_SIZE   = 1 << 20
+	MAX_NAME_LEN    = 128
+	MAX_DESC_LEN    = 1024
+	MAX_DATA_LEN    = 1024
+	MAX_USER_ID_LEN = 128
+	MAX_BATCH_SIZE  = 500
+)
+
+// 非空、不超过max个字符且不含控制字符
+func validText(s string, max int) bool {
+	if strings.TrimSpace(s) == "" || utf8.RuneCountInString(s) > max || !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// 可以为空的描述
+func validDescription(s string) bool {
+	return s == "" || validText(s, MAX_DESC_LEN)
+}
+
+// 用户Id只允许字母、数字和._@-，避免拼接到sso请求中时注入其他参数
+func validUserId(s string) bool {
+	if s == "" || len(s) > MAX_USER_ID_LEN {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._@-", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func validIds(ids []int) bool {
+	if len(ids) == 0 || len(ids) > MAX_BATCH_SIZE {
+		return false
+	}
+	for _, id := range ids {
+		if id <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func validUserIds(ids []string) bool {
+	if len(ids) == 0 || len(ids) > MAX_BATCH_SIZE {
+		return false
+	}
+	for _, id := range ids {
+		if !validUserId(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// 路由中的正整数参数
+func (c *Controller) pathId(key string) (int, bool) {
+	id, err := strconv.Atoi(c.Ctx.Input.Param(key))
+	return id, err == nil && id > 0
+}
+
+// 路由中的用户Id
+func (c *Controller) pathUserId() (string, bool) {
+	userId := c.Ctx.Input.Param(":userId")
+	return userId, validUserId(userId)
+}
+
+// 解析json请求体，超过MAX_BODY_SIZE或格式错误时返回false
+func (c *Controller) readJSON(v interface{}) bool {
+	body := c.Ctx.Input.CopyBody(MAX_BODY_SIZE)
+	if len(body) == 0 || len(body) >= MAX_BODY_SIZE {
+		return false
+	}
+	return json.Unmarshal(body, v) == nil
+}
+
+// 查询参数中的bool值，未传时为false
+func (c *Controller) queryBool(key string) (bool, bool) {
+	if c.GetString(key) == "" {
+		return false, true
+	}
+	v, err := c.GetBool(key)
+	return v, err == nil
+}
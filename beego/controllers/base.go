@@ -3,6 +3,7 @@ package controllers
 import (
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
+	"github.com/tongwu13/golang_common/beego/i18n"
 )
 
 // 对beego.Controller增加Ok InvalidParams Failed三个方法，分别用来处理逻辑正确、参数错误和逻辑出错的情况，以json的形式返回
@@ -11,36 +12,57 @@ type BaseController struct {
 	beego.Controller
 }
 
+// 内置错误码，应用自定义的错误码通过RegisterCode注册
+// Unauthorized、Forbidden、NotFound占用了3-5，应用原有的3-5错误码含义不同时会冲突（RegisterCode返回ErrCodeRegistered），
+// 自定义错误码建议从1000开始
 const (
 	OK = iota
-	invalidParameters
-	internalError
+	InvalidParameters
+	InternalError
+	Unauthorized
+	Forbidden
+	NotFound
 )
 
 type ResponseBody struct {
-	ResCode int         `json:"res_code"` // 0-成功  1-参数不合法  2-其他错误
+	ResCode int         `json:"res_code"` // 0-成功  1-参数不合法  2-其他错误  3-未登录  4-无权限  5-不存在（3-5为内置保留，勿另作他用）  其他见RegisterCode
 	ResMsg  string      `json:"res_msg"`
 	Data    interface{} `json:"data"`
 }
 
 func (c *BaseController) InvalidParams() {
 	logs.Error(c.Ctx.Request.URL.String() + " parameters invalid.")
-	c.Data["json"] = &ResponseBody{invalidParameters, "invalid parameters", nil}
-	c.ServeJSON()
+	c.respond(InvalidParameters, "", nil)
 }
 
 func (c *BaseController) Ok(data interface{}) {
-	c.Data["json"] = &ResponseBody{OK, "ok", &data}
-	c.ServeJSON()
+	c.respond(OK, "", &data)
 }
 
+// err的Unwrap链中有CodedError时按其错误码和状态码返回，否则返回InternalError和err的内容
 func (c *BaseController) Failed(err error) {
 	logs.Error(c.Ctx.Request.URL.String() + " " + err.Error())
-	c.Data["json"] = &ResponseBody{internalError, err.Error(), nil}
-	c.ServeJSON()
+	if e, ok := CodeOf(err); ok {
+		c.respond(e.Code, i18n.T(c.Ctx, e.message()), nil)
+		return
+	}
+	c.respond(InternalError, err.Error(), nil)
 }
 
 func (c *BaseController) Json(data interface{}) {
 	c.Data["json"] = &data
 	c.ServeJSON()
 }
+
+// msg为空时使用错误码注册的消息，按请求的语言翻译
+func (c *BaseController) respond(code int, msg string, data interface{}) {
+	ec, _ := LookupCode(code)
+	if msg == "" {
+		msg = i18n.T(c.Ctx, ec.Message)
+	}
+	if ec.Status != 0 && c.Ctx.Output.Status == 0 {
+		c.Ctx.Output.SetStatus(ec.Status)
+	}
+	c.Data["json"] = &ResponseBody{code, msg, data}
+	c.ServeJSON()
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tongwu13/golang_common/beego/i18n"
+	"net/http"
+	"sync"
+)
+
+// 错误码注册表：ResponseBody.ResCode对应的默认消息和HTTP状态码
+//
+//	const ORDER_NOT_FOUND = 1001
+//
+//	func init() {
+//		controllers.MustRegisterCode(ORDER_NOT_FOUND, "order not found", http.StatusNotFound)
+//	}
+//
+//	return controllers.Wrap(ORDER_NOT_FOUND, sql.ErrNoRows)
+//
+// Failed沿Unwrap链查找CodedError，以其错误码和状态码返回；内置错误码为兼容旧的调用方状态码均为200，可用SetCodeStatus修改
+var ErrCodeRegistered = errors.New("error code already registered")
+
+type ErrorCode struct {
+	Code    int
+	Message string // 默认的res_msg，返回时作为key经i18n.Default翻译
+	Status  int    // HTTP状态码
+}
+
+var (
+	codesLock sync.RWMutex
+	codes     = map[int]ErrorCode{
+		OK:                {OK, i18n.MSG_OK, http.StatusOK},
+		InvalidParameters: {InvalidParameters, i18n.MSG_INVALID_PARAMETERS, http.StatusOK},
+		InternalError:     {InternalError, i18n.MSG_INTERNAL_ERROR, http.StatusOK},
+		Unauthorized:      {Unauthorized, i18n.MSG_UNAUTHORIZED, http.StatusUnauthorized},
+		Forbidden:         {Forbidden, i18n.MSG_FORBIDDEN, http.StatusForbidden},
+		NotFound:          {NotFound, i18n.MSG_NOT_FOUND, http.StatusNotFound},
+	}
+)
+
+// 注册错误码，错误码已存在时返回ErrCodeRegistered
+func RegisterCode(code int, message string, status int) error {
+	if err := checkStatus(code, status); err != nil {
+		return err
+	}
+	codesLock.Lock()
+	defer codesLock.Unlock()
+	if _, ok := codes[code]; ok {
+		return ErrCodeRegistered
+	}
+	codes[code] = ErrorCode{code, message, status}
+	return nil
+}
+
+func checkStatus(code, status int) error {
+	if status < 100 || status > 599 {
+		return fmt.Errorf("invalid http status %d for code %d", status, code)
+	}
+	return nil
+}
+
+func MustRegisterCode(code int, message string, status int) {
+	if err := RegisterCode(code, message, status); err != nil {
+		panic(fmt.Sprintf("register code %d: %v", code, err))
+	}
+}
+
+// 修改已注册错误码的HTTP状态码
+func SetCodeStatus(code, status int) error {
+	if err := checkStatus(code, status); err != nil {
+		return err
+	}
+	codesLock.Lock()
+	defer codesLock.Unlock()
+	c, ok := codes[code]
+	if !ok {
+		return fmt.Errorf("code %d not registered", code)
+	}
+	c.Status = status
+	codes[code] = c
+	return nil
+}
+
+// 查询错误码，未注册时返回InternalError的消息和状态码
+func LookupCode(code int) (ErrorCode, bool) {
+	codesLock.RLock()
+	defer codesLock.RUnlock()
+	c, ok := codes[code]
+	if !ok {
+		c = codes[InternalError]
+		c.Code = code
+	}
+	return c, ok
+}
+
+// 带错误码的错误，Err为原始错误（只记录日志，不返回给调用方）
+type CodedError struct {
+	Code    int
+	Message string // 为空时使用注册的消息
+	Err     error
+}
+
+func (e *CodedError) Error() string {
+	msg := e.message()
+	if e.Err != nil {
+		return msg + ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CodedError) message() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	c, _ := LookupCode(e.Code)
+	return c.Message
+}
+
+// 创建错误码对应的错误，message为空时使用注册的消息
+func NewError(code int, message string) error {
+	return &CodedError{Code: code, Message: message}
+}
+
+// 为err附加错误码，err为nil时返回nil
+func Wrap(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+// 沿Unwrap链查找第一个CodedError
+func CodeOf(err error) (*CodedError, bool) {
+	for err != nil {
+		if e, ok := err.(*CodedError); ok {
+			return e, true
+		}
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return nil, false
+		}
+		err = u.Unwrap()
+	}
+	return nil, false
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/astaxie/beego/context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 测试用的错误码，避免与其他测试注册的冲突
+const (
+	testCodeRegister = 91001 + iota
+	testCodeFailed
+)
+
+// 不依赖fmt的%w，手动实现Unwrap
+type wrapped struct {
+	msg string
+	err error
+}
+
+func (w *wrapped) Error() string { return w.msg + ": " + w.err.Error() }
+func (w *wrapped) Unwrap() error { return w.err }
+
+func TestRegisterCode(t *testing.T) {
+	cases := []struct {
+		name    string
+		code    int
+		status  int
+		wantErr error
+		invalid bool
+	}{
+		{name: "new code", code: testCodeRegister, status: http.StatusConflict},
+		{name: "duplicate", code: testCodeRegister, status: http.StatusConflict, wantErr: ErrCodeRegistered},
+		{name: "builtin", code: NotFound, status: http.StatusNotFound, wantErr: ErrCodeRegistered},
+		{name: "status too small", code: testCodeRegister + 100, status: 99, invalid: true},
+		{name: "status too large", code: testCodeRegister + 100, status: 600, invalid: true},
+	}
+	for _, c := range cases {
+		err := RegisterCode(c.code, "conflict", c.status)
+		switch {
+		case c.invalid:
+			if err == nil || err == ErrCodeRegistered {
+				t.Errorf("%s: err = %v, want invalid status", c.name, err)
+			}
+		case err != c.wantErr:
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.wantErr)
+		}
+	}
+	if ec, ok := LookupCode(testCodeRegister); !ok || ec.Status != http.StatusConflict || ec.Message != "conflict" {
+		t.Errorf("LookupCode = %+v %v", ec, ok)
+	}
+	if ec, ok := LookupCode(testCodeRegister + 100); ok || ec.Code != testCodeRegister+100 || ec.Status != http.StatusOK {
+		t.Errorf("LookupCode unregistered = %+v %v, want InternalError defaults", ec, ok)
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	coded := NewError(NotFound, "")
+	cases := []struct {
+		name string
+		err  error
+		want int
+		ok   bool
+	}{
+		{"nil", nil, 0, false},
+		{"plain", errors.New("boom"), 0, false},
+		{"coded", coded, NotFound, true},
+		{"wrapped once", &wrapped{"load", coded}, NotFound, true},
+		{"wrapped twice", &wrapped{"handler", &wrapped{"load", Wrap(Forbidden, errors.New("denied"))}}, Forbidden, true},
+		{"wrapping plain", &wrapped{"load", errors.New("boom")}, 0, false},
+	}
+	for _, c := range cases {
+		e, ok := CodeOf(c.err)
+		if ok != c.ok || (ok && e.Code != c.want) {
+			t.Errorf("%s: CodeOf = %+v %v, want %d %v", c.name, e, ok, c.want, c.ok)
+		}
+	}
+	if Wrap(NotFound, nil) != nil {
+		t.Error("Wrap(nil) should be nil")
+	}
+}
+
+func TestFailed(t *testing.T) {
+	MustRegisterCode(testCodeFailed, "order locked", http.StatusLocked)
+	cases := []struct {
+		name       string
+		err        error
+		wantCode   int
+		wantMsg    string
+		wantStatus int
+	}{
+		{"plain error", errors.New("boom"), InternalError, "boom", http.StatusOK},
+		{"builtin code", NewError(NotFound, "order missing"), NotFound, "order missing", http.StatusNotFound},
+		{"registered code", Wrap(testCodeFailed, errors.New("row locked")), testCodeFailed, "order locked", http.StatusLocked},
+		{"wrapped chain", &wrapped{"handler", NewError(Forbidden, "no access")}, Forbidden, "no access", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		ctx := context.NewContext()
+		ctx.Reset(w, httptest.NewRequest(http.MethodGet, "/orders/1", nil))
+		controller := &BaseController{}
+		controller.Init(ctx, "", "", nil)
+		controller.Failed(c.err)
+
+		var body ResponseBody
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if body.ResCode != c.wantCode || body.ResMsg != c.wantMsg || w.Code != c.wantStatus {
+			t.Errorf("%s: got res_code %d res_msg %q status %d, want %d %q %d",
+				c.name, body.ResCode, body.ResMsg, w.Code, c.wantCode, c.wantMsg, c.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// 消息翻译：以原始消息为key，按cookie或Accept-Language协商的语言返回翻译
+//
+//	i18n.UseBuiltin() // 可选，启用内置的zh-CN和en-US翻译
+//	i18n.SetMessages("en-US", map[string]string{
+//		i18n.MSG_LOGIN_SUCCESS: "Signed in",
+//		"order not found":      "Order not found",
+//	})
+//	i18n.SetDefaultLocale("zh-CN")
+//
+// 语言依次取cookie（CookieName，默认为lang）、Accept-Language、默认语言；默认语言为空或消息没有翻译时返回key本身。
+// Default默认不包含任何翻译，未配置时保持原来的返回内容。
+// controllers.BaseController的res_msg和auth/filter的401/403/登录结果均使用Default。
+package i18n
+
+import (
+	"fmt"
+	"github.com/astaxie/beego/context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DEFAULT_COOKIE_NAME = "lang"
+
+// 内置消息的key，值为原来返回的内容
+const (
+	MSG_OK                 = "ok"
+	MSG_INVALID_PARAMETERS = "invalid parameters"
+	MSG_INTERNAL_ERROR     = "internal error"
+	MSG_UNAUTHORIZED       = "unauthorized"
+	MSG_FORBIDDEN          = "forbidden"
+	MSG_NOT_FOUND          = "not found"
+	MSG_LOGIN_SUCCESS      = "登录成功"
+	MSG_NOT_LOGIN          = "未授权或获取授权失败，访问被拒绝"
+	MSG_PERMISSION_DENIED  = "已授权，访问被拒绝，当前用户没有权限访问该内容"
+	MSG_API_KEY_INVALID    = "api key无效或已过期，访问被拒绝"
+	MSG_CLIENT_NOT_FOUND   = "未找到对应的sso client，访问被拒绝"
+)
+
+type Catalog struct {
+	CookieName string // 保存语言的cookie，为空时不读取cookie
+
+	lock          sync.RWMutex
+	defaultLocale string
+	messages      map[string]map[string]string // 小写的语言 - key - 翻译
+	names         map[string]string            // 小写的语言 - 注册时的名称
+}
+
+func NewCatalog(defaultLocale string) *Catalog {
+	return &Catalog{
+		CookieName:    DEFAULT_COOKIE_NAME,
+		defaultLocale: defaultLocale,
+		messages:      make(map[string]map[string]string),
+		names:         make(map[string]string),
+	}
+}
+
+// 添加或覆盖locale的翻译
+func (c *Catalog) SetMessages(locale string, messages map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	lower := strings.ToLower(locale)
+	m, ok := c.messages[lower]
+	if !ok {
+		m = make(map[string]string, len(messages))
+		c.messages[lower] = m
+		c.names[lower] = locale
+	}
+	for k, v := range messages {
+		m[k] = v
+	}
+}
+
+func (c *Catalog) SetDefaultLocale(locale string) {
+	c.lock.Lock()
+	c.defaultLocale = locale
+	c.lock.Unlock()
+}
+
+func (c *Catalog) DefaultLocale() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.defaultLocale
+}
+
+// 已有翻译的语言
+func (c *Catalog) Locales() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	locales := make([]string, 0, len(c.names))
+	for _, name := range c.names {
+		locales = append(locales, name)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// 返回key在locale下的翻译，没有时依次尝试主语言（zh-TW取zh）和默认语言，都没有时返回key；有args时按fmt格式化
+func (c *Catalog) Translate(locale, key string, args ...interface{}) string {
+	msg := c.lookup(locale, key)
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+func (c *Catalog) lookup(locale, key string) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, l := range []string{locale, baseLanguage(locale), c.defaultLocale} {
+		if m, ok := c.messages[strings.ToLower(l)]; ok && l != "" {
+			if msg, ok := m[key]; ok {
+				return msg
+			}
+		}
+	}
+	return key
+}
+
+// 在已有翻译的语言中匹配tag，先完全匹配再按主语言匹配
+func (c *Catalog) match(tag string) (string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	lower := strings.ToLower(tag)
+	if name, ok := c.names[lower]; ok {
+		return name, true
+	}
+	base := baseLanguage(lower)
+	if name, ok := c.names[base]; ok {
+		return name, true
+	}
+	candidates := make([]string, 0, 1)
+	for l, name := range c.names {
+		if baseLanguage(l) == base {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.Strings(candidates)
+	return candidates[0], true
+}
+
+// 按Accept-Language的q值选择已有翻译的语言，没有匹配时返回默认语言
+func (c *Catalog) Negotiate(acceptLanguage string) string {
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		if locale, ok := c.match(tag); ok {
+			return locale
+		}
+	}
+	return c.DefaultLocale()
+}
+
+// 请求使用的语言
+func (c *Catalog) Locale(ctx *context.Context) string {
+	if c.CookieName != "" {
+		if lang := ctx.GetCookie(c.CookieName); lang != "" {
+			if locale, ok := c.match(lang); ok {
+				return locale
+			}
+		}
+	}
+	return c.Negotiate(ctx.Input.Header("Accept-Language"))
+}
+
+// 按请求的语言翻译
+func (c *Catalog) T(ctx *context.Context, key string, args ...interface{}) string {
+	return c.Translate(c.Locale(ctx), key, args...)
+}
+
+// zh-CN取zh
+func baseLanguage(tag string) string {
+	if idx := strings.IndexAny(tag, "-_"); idx > 0 {
+		return tag[:idx]
+	}
+	return tag
+}
+
+// 按q值从高到低返回语言，忽略*和q=0
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// 应用使用的翻译，默认没有任何翻译且默认语言为空（返回原来的内容）
+var Default = NewCatalog("")
+
+// 内置消息的翻译，由UseBuiltin加入Default
+var Builtin = map[string]map[string]string{
+	"zh-CN": {
+		MSG_OK:                 "成功",
+		MSG_INVALID_PARAMETERS: "参数不合法",
+		MSG_INTERNAL_ERROR:     "内部错误",
+		MSG_UNAUTHORIZED:       "未登录",
+		MSG_FORBIDDEN:          "没有权限",
+		MSG_NOT_FOUND:          "不存在",
+		MSG_LOGIN_SUCCESS:      "登录成功",
+		MSG_NOT_LOGIN:          "未授权或获取授权失败，访问被拒绝",
+		MSG_PERMISSION_DENIED:  "已授权，访问被拒绝，当前用户没有权限访问该内容",
+		MSG_API_KEY_INVALID:    "api key无效或已过期，访问被拒绝",
+		MSG_CLIENT_NOT_FOUND:   "未找到对应的sso client，访问被拒绝",
+	},
+	"en-US": {
+		MSG_OK:                 "ok",
+		MSG_INVALID_PARAMETERS: "invalid parameters",
+		MSG_INTERNAL_ERROR:     "internal error",
+		MSG_UNAUTHORIZED:       "unauthorized",
+		MSG_FORBIDDEN:          "forbidden",
+		MSG_NOT_FOUND:          "not found",
+		MSG_LOGIN_SUCCESS:      "Logged in",
+		MSG_NOT_LOGIN:          "Not logged in or authorization failed, access denied",
+		MSG_PERMISSION_DENIED:  "Access denied, the current user has no permission to access this content",
+		MSG_API_KEY_INVALID:    "Invalid or expired api key, access denied",
+		MSG_CLIENT_NOT_FOUND:   "No matching sso client, access denied",
+	},
+}
+
+// 将内置翻译加入Default，之后按请求协商的语言返回内置消息；应用自己的翻译应在之后设置以覆盖内置翻译
+func UseBuiltin() {
+	for locale, messages := range Builtin {
+		Default.SetMessages(locale, messages)
+	}
+}
+
+// 以下为Default的快捷方法
+
+func SetMessages(locale string, messages map[string]string) {
+	Default.SetMessages(locale, messages)
+}
+
+func SetDefaultLocale(locale string) {
+	Default.SetDefaultLocale(locale)
+}
+
+func T(ctx *context.Context, key string, args ...interface{}) string {
+	return Default.T(ctx, key, args...)
+}
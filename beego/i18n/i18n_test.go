@@ -0,0 +1,82 @@
+package i18n
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	cases := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"zh-CN", []string{"zh-CN"}},
+		{"en;q=0.5, zh-CN", []string{"zh-CN", "en"}},
+		{"fr;q=0.8, de;q=0.8, *;q=0.1", []string{"fr", "de"}},
+		{"ja;q=0, en", []string{"en"}},
+		{"en;q=abc", []string{"en"}},
+	}
+	for _, c := range cases {
+		if got := parseAcceptLanguage(c.header); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseAcceptLanguage(%q) = %v, want %v", c.header, got, c.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	c := NewCatalog("en-US")
+	c.SetMessages("zh-CN", map[string]string{MSG_OK: "成功"})
+	c.SetMessages("en-US", map[string]string{MSG_OK: "ok"})
+	cases := []struct {
+		header string
+		want   string
+	}{
+		{"", "en-US"},
+		{"zh-CN", "zh-CN"},
+		{"zh-cn", "zh-CN"},
+		{"zh", "zh-CN"},
+		{"zh-TW", "zh-CN"},
+		{"fr, zh;q=0.5", "zh-CN"},
+		{"en;q=0.3, zh;q=0.9", "zh-CN"},
+		{"fr", "en-US"},
+		{"zh;q=0", "en-US"},
+	}
+	for _, c2 := range cases {
+		if got := c.Negotiate(c2.header); got != c2.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", c2.header, got, c2.want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	c := NewCatalog("")
+	c.SetMessages("zh", map[string]string{MSG_OK: "成功", "%d items": "%d项"})
+	cases := []struct {
+		locale, key string
+		args        []interface{}
+		want        string
+	}{
+		{"zh", MSG_OK, nil, "成功"},
+		{"zh-TW", MSG_OK, nil, "成功"},
+		{"en", MSG_OK, nil, MSG_OK},
+		{"", MSG_OK, nil, MSG_OK},
+		{"zh", MSG_FORBIDDEN, nil, MSG_FORBIDDEN},
+		{"zh", "%d items", []interface{}{3}, "3项"},
+	}
+	for _, c2 := range cases {
+		if got := c.Translate(c2.locale, c2.key, c2.args...); got != c2.want {
+			t.Errorf("Translate(%q, %q) = %q, want %q", c2.locale, c2.key, got, c2.want)
+		}
+	}
+}
+
+// 未调用UseBuiltin时不应协商到任何语言，保持原来的返回内容
+func TestDefaultHasNoLocales(t *testing.T) {
+	if locales := Default.Locales(); len(locales) != 0 {
+		t.Fatalf("Default.Locales() = %v, want none", locales)
+	}
+	if got := Default.Translate(Default.Negotiate("zh-CN,zh;q=0.9"), MSG_OK); got != MSG_OK {
+		t.Fatalf("translate without builtin = %q, want %q", got, MSG_OK)
+	}
+}
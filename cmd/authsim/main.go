@@ -0,0 +1,111 @@
+// authsim 离线模拟鉴权规则
+//
+// 查询用户能否访问某个路由：
+//
+//	authsim -config conf/app.conf -user tom -method POST -route /orders
+//
+// 比较两份配置的规则，列出全部用户中访问权限发生变化的路由：
+//
+//	authsim -config conf/app.conf -diff conf/app.new.conf
+//
+// 配置文件为beego的ini格式，鉴权规则读取-section指定的section（见filter.ConfigFromAppConfig），
+// sso接口配置读取-api指定的section（见filter.ApiConfigFromAppConfig）。
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/astaxie/beego/config"
+	"github.com/tongwu13/golang_common/auth/filter"
+	"os"
+	"strings"
+)
+
+func main() {
+	configPath := flag.String("config", "conf/app.conf", "beego ini config file")
+	section := flag.String("section", "auth", "section of auth rules")
+	apiSection := flag.String("api", "sso", "section of sso api config")
+	userId := flag.String("user", "", "user id to check")
+	method := flag.String("method", "GET", "request method")
+	route := flag.String("route", "", "router pattern to check")
+	diffPath := flag.String("diff", "", "config file with the new rules to compare")
+	asJSON := flag.Bool("json", false, "print result as json")
+	flag.Parse()
+
+	conf, err := config.NewConfig("ini", *configPath)
+	exitOn(err)
+	before, err := loadAuth(conf, *section)
+	exitOn(err)
+	api, err := filter.NewApiAuthE(filter.ApiConfigFromAppConfig(conf, *apiSection))
+	exitOn(err)
+	sim := filter.NewSimulator(api)
+
+	if *diffPath != "" {
+		newConf, err := config.NewConfig("ini", *diffPath)
+		exitOn(err)
+		after, err := loadAuth(newConf, *section)
+		exitOn(err)
+		changes, err := sim.Diff(before, after, nil)
+		exitOn(err)
+		if *asJSON {
+			printJSON(changes)
+			return
+		}
+		for _, c := range changes {
+			change := "lose"
+			if c.Gained() {
+				change = "gain"
+			}
+			// 复制后再追加，避免写入Before.Missing的底层数组
+			missing := make([]string, 0, len(c.Before.Missing)+len(c.After.Missing))
+			missing = append(append(missing, c.Before.Missing...), c.After.Missing...)
+			fmt.Printf("%s\t%s\t%s %s\t%s\n", c.UserId, change, c.Route.Method, c.Route.Route, strings.Join(missing, ","))
+		}
+		return
+	}
+
+	if *userId == "" || *route == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	decision, err := sim.Check(before, *userId, *method, *route)
+	exitOn(err)
+	if *asJSON {
+		printJSON(decision)
+		return
+	}
+	result := "allow"
+	if !decision.Allowed {
+		result = "deny"
+	}
+	fmt.Printf("%s\trule=%s\tmissing=%s\n", result, decision.Rule, strings.Join(decision.Missing, ","))
+	if !decision.Allowed {
+		os.Exit(1)
+	}
+}
+
+func loadAuth(conf config.Configer, section string) (*filter.Auth, error) {
+	c, err := filter.ConfigFromAppConfig(conf, section)
+	if err != nil {
+		return nil, err
+	}
+	service, err := filter.NewAuthServiceE(c)
+	if err != nil {
+		return nil, err
+	}
+	return service.(*filter.Auth), nil
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	exitOn(enc.Encode(v))
+}
+
+func exitOn(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "authsim:", err)
+		os.Exit(1)
+	}
+}